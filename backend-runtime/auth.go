@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type Role string
+
+const (
+	IngestRole Role = "ingest" // Services shipping records, also allowed to replay their own dependencies and observations
+	ReadRole   Role = "read"   // CLI and operators reading captures
+
+	// Services in debug mode send their own Authorization header to dependencies,
+	// so their token is sent to the runtime in a separate header
+	RuntimeAuthorizationHeader = "X-Runtime-Authorization"
+)
+
+type Token struct {
+	Token   string `json:"token"`
+	Role    Role   `json:"role"`
+	Service string `json:"service"` // Ingest tokens can only record records of this service
+}
+
+type principalKey struct{}
+
+type Authenticator struct {
+	tokens map[[sha256.Size]byte]Token
+}
+
+// LoadTokens reads a JSON array of tokens
+func LoadTokens(path string) (*Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]Token, 0)
+	if err := json.Unmarshal(data, &tokens); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	a := &Authenticator{tokens: make(map[[sha256.Size]byte]Token, len(tokens))}
+	for _, t := range tokens {
+		if t.Token == "" {
+			return nil, fmt.Errorf("%s: empty token", path)
+		}
+		if t.Role != IngestRole && t.Role != ReadRole {
+			return nil, fmt.Errorf("%s: unknown role %q", path, t.Role)
+		}
+		if t.Role == IngestRole && t.Service == "" {
+			return nil, fmt.Errorf("%s: ingest token without service", path)
+		}
+		// Tokens are looked up by hash so the lookup doesn't leak how much of a token matched
+		a.tokens[sha256.Sum256([]byte(t.Token))] = t
+	}
+	return a, nil
+}
+
+func bearerToken(r *http.Request) string {
+	for _, name := range []string{RuntimeAuthorizationHeader, "Authorization"} {
+		if token, ok := strings.CutPrefix(r.Header.Get(name), "Bearer "); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// Require only lets requests through with a token of one of the roles. A nil Authenticator allows everything.
+func (a *Authenticator) Require(handler http.HandlerFunc, roles ...Role) http.HandlerFunc {
+	if a == nil {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := a.tokens[sha256.Sum256([]byte(bearerToken(r)))]
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		for _, role := range roles {
+			if token.Role == role {
+				handler(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, token)))
+				return
+			}
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}
+}
+
+// authorizedService returns the service an ingest token is restricted to, empty when there is no restriction
+func authorizedService(r *http.Request) string {
+	if token, ok := r.Context().Value(principalKey{}).(Token); ok && token.Role == IngestRole {
+		return token.Service
+	}
+	return ""
+}
+
+// replayAllowed tells if the request may replay the calls made in execution context ec of rc.
+// Ingest tokens are restricted to the execution contexts of their own service.
+func replayAllowed(r *http.Request, rc, ec string) (bool, error) {
+	service := authorizedService(r)
+	if service == "" {
+		return true, nil
+	}
+
+	rec, err := findFirst(rc, RecordKey{RecordType: RequestRecordType, ExecutionContext: ec})
+	if err != nil {
+		return false, err
+	}
+	return strings.EqualFold(rec.ServiceName, service), nil
+}
+
+// forwardAllowed tells if the request may use a replay config that calls out, to mapped hosts or live.
+// Ingest tokens only get to call out with plans stored by a read token, as the calls carry the recorded headers.
+func forwardAllowed(r *http.Request, dc string, config replayConfig) bool {
+	if authorizedService(r) == "" || strings.HasPrefix(dc, storedPlanPrefix) {
+		return true
+	}
+	return len(config.hosts) == 0 && config.missing != missingLive
+}
+
+// TLSConfig requires and verifies client certificates signed by the CA when a CA file is given
+func TLSConfig(clientCA string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if clientCA == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s: no certificates found", clientCA)
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAuthenticatorRequire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	os.WriteFile(path, []byte(`[
+		{"token": "service-a", "role": "ingest", "service": "ServiceA"},
+		{"token": "cli", "role": "read"}
+	]`), 0o600)
+
+	auth, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := ""
+	handler := auth.Require(func(w http.ResponseWriter, r *http.Request) {
+		service = authorizedService(r)
+		w.WriteHeader(http.StatusOK)
+	}, IngestRole)
+
+	cases := []struct {
+		header string
+		token  string
+		status int
+	}{
+		{"", "", http.StatusUnauthorized},
+		{"Authorization", "wrong", http.StatusUnauthorized},
+		{"Authorization", "cli", http.StatusForbidden},
+		{"Authorization", "service-a", http.StatusOK},
+		{RuntimeAuthorizationHeader, "service-a", http.StatusOK},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/runtime/record", nil)
+		if c.header != "" {
+			r.Header.Set(c.header, "Bearer "+c.token)
+		}
+		w := httptest.NewRecorder()
+		handler(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s Want %v Actual %v\n", c.header, c.token, c.status, w.Code)
+		}
+	}
+
+	if service != "ServiceA" {
+		t.Errorf("Want %v Actual %v\n", "ServiceA", service)
+	}
+}
+
+func TestReplayRestrictedToService(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	os.WriteFile(path, []byte(`[
+		{"token": "service-a", "role": "ingest", "service": "ServiceA"},
+		{"token": "cli", "role": "read"}
+	]`), 0o600)
+	auth, err := LoadTokens(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: RequestRecordType, ServiceName: "ServiceA", Method: "GET", Uri: "/"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", StatusCode: 200},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceA", ObservationName: "Now", Body: []byte("1")},
+		{RequestContext: "rc", ExecutionContext: "e2", RecordType: RequestRecordType, ServiceName: "ServiceB", Method: "POST", Uri: "/count"},
+		{RequestContext: "rc", ExecutionContext: "e2", RecordType: DependencyRequestRecordType, ServiceName: "ServiceB", Method: "POST", Uri: "http://c/stock"},
+		{RequestContext: "rc", ExecutionContext: "e2", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://c/stock", StatusCode: 200},
+		{RequestContext: "rc", ExecutionContext: "e2", RecordType: ObservedRecordType, ServiceName: "ServiceB", ObservationName: "Secret", Body: []byte("2")},
+	})
+
+	defer func(saved *planStore) { plans = saved }(plans)
+	plans = &planStore{plans: make(map[string]ReplayPlan)}
+	id, err := plans.Add(ReplayPlan{Version: 1, Mapping: map[string]string{"servicec": "localhost:3002"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ingest tokens only call out with plans stored by a read token
+	mapped := inlinePlan(`{"version": 1, "mapping": {"servicec": "localhost:3002"}}`)
+	live := inlinePlan(`{"version": 1, "missing": {"policy": "live"}}`)
+
+	proxy := auth.Require(proxyHandler, IngestRole, ReadRole)
+	cases := []struct {
+		token  string
+		cc     string
+		uri    string
+		config string
+		status int
+	}{
+		{"service-a", "e1", "http://b/count", "", http.StatusOK},
+		{"service-a", "e2", "http://c/stock", "", http.StatusForbidden},
+		{"service-a", "unknown", "http://c/stock", "", http.StatusForbidden},
+		{"cli", "e2", "http://c/stock", "", http.StatusOK},
+		{"service-a", "e1", "http://b/count", mapped, http.StatusForbidden},
+		{"service-a", "e1", "http://b/count", live, http.StatusForbidden},
+		{"service-a", "e1", "http://b/count", "servicec=localhost:3002", http.StatusForbidden},
+		{"service-a", "e1", "http://b/count", storedPlanPrefix + id, http.StatusOK},
+		{"cli", "e1", "http://b/count", mapped, http.StatusOK},
+	}
+	for _, c := range cases {
+		r := proxyRequest("rc", c.cc, c.uri, "", c.config, "0")
+		r.Header.Set("Authorization", "Bearer "+c.token)
+		w := httptest.NewRecorder()
+		proxy(w, r)
+		if w.Code != c.status {
+			t.Errorf("%s %s %s Want %v Actual %v\n", c.token, c.cc, c.config, c.status, w.Code)
+		}
+	}
+
+	observations := auth.Require(observationHandler, IngestRole, ReadRole)
+	for token, want := range map[string]int{"service-a": 1, "cli": 2} {
+		r := httptest.NewRequest("GET", "/runtime/observations", nil)
+		r.Header.Set(RequestContextHeader, "rc")
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		observations(w, r)
+
+		var obs Observations
+		if err := json.Unmarshal(w.Body.Bytes(), &obs); err != nil {
+			t.Fatal(err)
+		}
+		if _, secret := obs.Data["Secret"]; len(obs.Data) != want || (token == "service-a" && secret) {
+			t.Errorf("%s Want %v Actual %v\n", token, want, obs.Data)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 )
 
@@ -40,8 +48,11 @@ type Record struct {
 	Uri                 string              `json:"ru"`
 	Header              map[string][]string `json:"he"`
 	Body                []byte              `json:"bd"`
-	ObservationError    []byte              `json:"oe"`
+	ObservationError    []byte              `json:"oe"` // Error of an observation
 	StatusCode          int                 `json:"st"`
+	Envelope            []byte              `json:"ev,omitempty"` // Encrypted Header, Body, ObservationError and TransportError when stored encrypted
+	Redacted            []string            `json:"rd,omitempty"` // Paths of the JSON body redacted by the SDK, like items.0.card
+	TransportError      string              `json:"te,omitempty"` // Error of a dependency call that got no response, like "refused: dial tcp ..."
 }
 
 type Request struct {
@@ -57,26 +68,168 @@ type Dependency struct {
 	Reference Request `json:"ref"`
 }
 
-var (
-	data  map[string][]Record
-	rwMux sync.RWMutex
-)
+var store Store
 
 func main() {
+	addr := flag.String("addr", ":8080", "Address to listen on")
+	storeKind := flag.String("store", "segment", "Record store, memory or segment")
+	dataDir := flag.String("data-dir", "data", "Directory of the segment store")
+	segmentSize := flag.Int64("segment-size", 64<<20, "Size in bytes after which a new segment is started")
+	compactInterval := flag.Duration("compact-interval", time.Hour, "Interval between segment compactions, 0 disables compaction")
+	maxAge := flag.Duration("retention-max-age", 0, "Evict request contexts captured longer ago than this, 0 keeps them forever")
+	maxBytes := flag.Int64("retention-max-bytes", 0, "Evict the oldest request contexts once the stored records exceed this many bytes, 0 disables the limit")
+	maxContexts := flag.Int("retention-max-contexts", 0, "Evict the oldest request contexts once more than this many are stored, 0 disables the limit")
+	evictInterval := flag.Duration("retention-interval", time.Minute, "Interval between eviction runs")
+	tailSampling := flag.Bool("tail-sampling", false, "Only keep request trees that failed, were slow or match a sampling rule")
+	samplingGrace := flag.Duration("sampling-grace", 15*time.Second, "Time without new records after which a request tree is sampled")
+	samplingLatency := flag.Duration("sampling-latency", 0, "Keep request trees with an edge response slower than this, 0 disables")
+	samplingRules := flag.String("sampling-rules", "", "JSON file with additional rules of request trees to keep")
+	keyFile := flag.String("key-file", "", "Keyring used to encrypt stored records, encryption is disabled when empty")
+	addKey := flag.String("add-key", "", "Generate a new active key with this id in the key file and exit")
+	reencrypt := flag.Bool("reencrypt", false, "Re-encrypt all stored records with the active key before starting")
+	tokensFile := flag.String("tokens", "", "JSON file of the tokens allowed to access the runtime, authentication is disabled when empty")
+	tlsCert := flag.String("tls-cert", "", "Certificate file, serves HTTPS when set")
+	tlsKey := flag.String("tls-key", "", "Private key file of the certificate")
+	tlsClientCA := flag.String("tls-client-ca", "", "CA file to verify client certificates with, enables mutual TLS")
+	flag.Parse()
+
+	if *addKey != "" {
+		if err := AddKey(*keyFile, *addKey); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Added key %s to %s\n", *addKey, *keyFile)
+		return
+	}
+
 	fmt.Println("Starting backend runtime")
 
-	data = make(map[string][]Record)
+	pinsPath, plansPath := "", ""
+
+	switch *storeKind {
+	case "memory":
+		store = NewMemoryStore()
+	case "segment":
+		pinsPath = filepath.Join(*dataDir, "pins.json")
+		plansPath = filepath.Join(*dataDir, "plans.json")
+		segments, err := OpenSegmentStore(*dataDir, *segmentSize)
+		if err != nil {
+			panic(err)
+		}
+		if *compactInterval > 0 {
+			go compactInBackground(segments, *compactInterval)
+		}
+		store = segments
+	default:
+		panic(fmt.Errorf("unknown store %s", *storeKind))
+	}
+
+	if *keyFile != "" {
+		keys := LoadKeyring(*keyFile)
+		encrypted := NewEncryptedStore(store, keys)
+		if *reencrypt {
+			if err := encrypted.Reencrypt(); err != nil {
+				panic(err)
+			}
+		}
+		go reloadOnHangup(keys)
+		store = encrypted
+	}
+
+	if *tailSampling {
+		policy := TailSampling{
+			Grace:            *samplingGrace,
+			LatencyThreshold: *samplingLatency,
+		}
+		if *samplingRules != "" {
+			rules, err := LoadSamplingRules(*samplingRules)
+			if err != nil {
+				panic(err)
+			}
+			policy.Rules = rules
+		}
+		store = NewTailSampler(store, policy)
+	}
+	defer store.Close()
+
+	pins, err := NewPinSet(pinsPath)
+	if err != nil {
+		panic(err)
+	}
+	if plans, err = NewPlanStore(plansPath); err != nil {
+		panic(err)
+	}
+
+	retention := Retention{
+		MaxAge:      *maxAge,
+		MaxBytes:    *maxBytes,
+		MaxContexts: *maxContexts,
+	}
+	if retention.Enabled() {
+		go evictInBackground(store, pins, retention, *evictInterval)
+	}
 
-	http.HandleFunc("/runtime/record", recordHandler)
-	http.HandleFunc("/runtime/replay", replayHandler)
-	http.HandleFunc("/runtime/proxy", proxyHandler)
-	http.HandleFunc("/runtime/observations", observationHandler)
+	var auth *Authenticator
+	if *tokensFile != "" {
+		if auth, err = LoadTokens(*tokensFile); err != nil {
+			panic(err)
+		}
+	} else {
+		fmt.Println("WARNING: authentication is disabled, anyone who can reach the runtime can read every capture")
+	}
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	http.HandleFunc("/runtime/record", auth.Require(recordHandler, IngestRole))
+	http.HandleFunc("/runtime/replay", auth.Require(replayHandler, ReadRole))
+	http.HandleFunc("/runtime/proxy", auth.Require(proxyHandler, IngestRole, ReadRole))
+	http.HandleFunc("/runtime/observations", auth.Require(observationHandler, IngestRole, ReadRole))
+	http.HandleFunc("/runtime/pin", auth.Require(pinHandler(pins), ReadRole))
+	http.HandleFunc("/runtime/search", auth.Require(searchHandler, ReadRole))
+	http.HandleFunc("/runtime/replay/run", auth.Require(replayRunHandler, ReadRole))
+	http.HandleFunc("/runtime/divergences", auth.Require(divergenceHandler, ReadRole))
+	http.HandleFunc("/runtime/sessions", auth.Require(sessionHandler, ReadRole))
+	http.HandleFunc("/runtime/plans", auth.Require(planHandler, ReadRole))
+	http.Handle("/ui/", uiHandler())
+	http.Handle("/{$}", http.RedirectHandler("/ui/", http.StatusFound))
+
+	server := &http.Server{Addr: *addr}
+	go shutdownOnSignal(server)
+
+	if *tlsCert == "" {
+		err = server.ListenAndServe()
+	} else {
+		if server.TLSConfig, err = TLSConfig(*tlsClientCA); err != nil {
+			panic(err)
+		}
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	}
+	if err != nil && err != http.ErrServerClosed {
 		panic(err)
 	}
 }
 
+// shutdownOnSignal stops the server on SIGINT or SIGTERM, main then closes the store so pending records are written
+func shutdownOnSignal(server *http.Server) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		fmt.Printf("ERROR: shutdown failed %v\n", err)
+	}
+}
+
+func compactInBackground(s *segmentStore, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.Compact(); err != nil {
+			fmt.Printf("ERROR: compaction failed: %s\n", err.Error())
+		}
+	}
+}
+
 func recordHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		badRequest bool
@@ -97,7 +250,8 @@ func recordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if len(r.Header["Content-Type"]) == 0 || r.Header["Content-Type"][0] != "application/json" {
+	contentType := r.Header.Get("Content-Type")
+	if contentType != "application/json" && contentType != RecordStreamContentType {
 		badRequest = true
 		return
 	}
@@ -107,25 +261,91 @@ func recordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	body, oErr := io.ReadAll(r.Body)
+	var reader io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			badRequest = true
+			return
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		badRequest = true
+		return
+	}
+
+	if contentType == RecordStreamContentType {
+		if ingestStream(w, r, NewRecordReader(reader)) {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		return
+	}
+
+	body, oErr := io.ReadAll(reader)
 	if oErr != nil {
 		return
 	}
 
-	rwMux.Lock()
-	defer rwMux.Unlock()
 	records := make([]Record, 0)
 
 	if oErr = json.Unmarshal(body, &records); oErr != nil {
 		return
 	}
 
-	for _, rc := range records {
-		data[rc.RequestContext] = append(data[rc.RequestContext], rc)
+	if !ingest(w, r, records) {
+		return
 	}
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// ingestStream decodes the records of a stream one at a time and appends them once the whole
+// stream is read. A stream cut short by an error is never stored in part, so the retry of the
+// SDK doesn't store records twice.
+func ingestStream(w http.ResponseWriter, r *http.Request, rr *RecordReader) bool {
+	records := make([]Record, 0)
+	for {
+		rec, err := rr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("ERROR: %s\n", err.Error())
+			w.WriteHeader(http.StatusBadRequest)
+			return false
+		}
+
+		records = append(records, rec)
+	}
+
+	return len(records) == 0 || ingest(w, r, records)
+}
+
+// ingest checks the records against the token and appends them, writing the error status when it fails
+func ingest(w http.ResponseWriter, r *http.Request, records []Record) bool {
+	if service := authorizedService(r); service != "" {
+		for i := range records {
+			if !strings.EqualFold(records[i].ServiceName, service) {
+				w.WriteHeader(http.StatusForbidden)
+				return false
+			}
+		}
+	}
+
+	if err := store.Append(records); err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return false
+	}
+	return true
+}
+
 func replayHandler(w http.ResponseWriter, r *http.Request) {
 	var (
 		badRequest bool
@@ -154,68 +374,68 @@ func replayHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rwMux.RLock()
-	defer rwMux.RUnlock()
-	if records, ok := data[rc]; ok {
-
-		ec := ""
-		// Find the initial request
-		for _, r := range records {
-			// Records of inital request has cause context as request context
-			if r.RequestContext == rc && r.CauseContext == rc {
-				ec = r.ExecutionContext
-				break
-			}
+	records, err := store.Get(rc)
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
 		}
+		return
+	}
 
-		req := buildRequestTree(records, ec)
-		if body, oErr := json.Marshal(req); oErr == nil {
-			w.Header().Add("Content-Type", "application/json")
-			_, oErr = w.Write(body)
-		}
-	} else {
-		w.WriteHeader(http.StatusNotFound)
+	req := buildRequestTree(records, edgeExecutionContext(records, rc))
+	if body, oErr := json.Marshal(req); oErr == nil {
+		w.Header().Add("Content-Type", "application/json")
+		_, oErr = w.Write(body)
 	}
 }
 
 func buildRequestTree(records []Record, ec string) Request {
+	// Records are grouped by execution context once, instead of every level of the tree scanning all of them
+	byExecution := make(map[string][]Record)
+	for i := range records {
+		byExecution[records[i].ExecutionContext] = append(byExecution[records[i].ExecutionContext], records[i])
+	}
+	return buildRequest(byExecution, ec)
+}
+
+func buildRequest(byExecution map[string][]Record, ec string) Request {
+	records := byExecution[ec]
+	// Guards against cycles of a corrupt capture
+	delete(byExecution, ec)
+
 	req := Request{
 		Dependencies: make([]Dependency, len(records)),
 		Observations: make([]Record, len(records)),
 	}
 
-	notUsed := make([]Record, 0, len(records))
-
 	maxGsq := -1
 	maxOsq := -1
 
 	for i := range records {
-		if records[i].ExecutionContext == ec {
-			switch records[i].RecordType {
-			case RequestRecordType:
-				req.In = records[i]
-			case ResponseRecordType:
-				req.Out = records[i]
-			case DependencyRequestRecordType:
-				req.Dependencies[records[i].DepencencySequence].In = records[i]
-				if records[i].DepencencySequence > maxGsq {
-					maxGsq = records[i].DepencencySequence
-				}
-			case DependencyResponseRecordType:
-				req.Dependencies[records[i].DepencencySequence].Out = records[i]
-				if records[i].DepencencySequence > maxGsq {
-					maxGsq = records[i].DepencencySequence
-				}
-			case ObservedRecordType:
-				req.Observations[records[i].ObservationSequence] = records[i]
-				if records[i].ObservationSequence > maxOsq {
-					maxOsq = records[i].ObservationSequence
-				}
-			default:
-				fmt.Printf("Unknown record %v\n", records[i])
+		switch records[i].RecordType {
+		case RequestRecordType:
+			req.In = records[i]
+		case ResponseRecordType:
+			req.Out = records[i]
+		case DependencyRequestRecordType:
+			req.Dependencies[records[i].DepencencySequence].In = records[i]
+			if records[i].DepencencySequence > maxGsq {
+				maxGsq = records[i].DepencencySequence
 			}
-		} else {
-			notUsed = append(notUsed, records[i])
+		case DependencyResponseRecordType:
+			req.Dependencies[records[i].DepencencySequence].Out = records[i]
+			if records[i].DepencencySequence > maxGsq {
+				maxGsq = records[i].DepencencySequence
+			}
+		case ObservedRecordType:
+			req.Observations[records[i].ObservationSequence] = records[i]
+			if records[i].ObservationSequence > maxOsq {
+				maxOsq = records[i].ObservationSequence
+			}
+		default:
+			fmt.Printf("Unknown record %v\n", records[i])
 		}
 	}
 
@@ -224,7 +444,7 @@ func buildRequestTree(records []Record, ec string) Request {
 
 	for i := range req.Dependencies {
 		if req.Dependencies[i].In.DependencyContext != "" {
-			req.Dependencies[i].Reference = buildRequestTree(notUsed, req.Dependencies[i].In.DependencyContext)
+			req.Dependencies[i].Reference = buildRequest(byExecution, req.Dependencies[i].In.DependencyContext)
 		}
 	}
 
@@ -239,6 +459,8 @@ const (
 	DebugConfigHeader              = "X-Debug-Config"
 	DepencencySequenceHeader       = "X-Dependency-Sequence"
 	ScopedDependencySequenceHeader = "X-Scoped-Dependency-Sequence"
+	ReplayContextHeader            = "X-Replay-Context"
+	TransportErrorHeader           = "X-Replay-Transport-Error"
 
 	DebugEnabled = "ENABLED"
 )
@@ -273,96 +495,254 @@ func proxyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	mapping := parseDebugConfig(dc)
+	config, err := loadReplayConfig(dc)
+	if err != nil {
+		badRequest = true
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
 
-	rwMux.RLock()
-	defer rwMux.RUnlock()
-	if records, ok := data[rc]; ok {
-		var depRes, depInReq Record
-		for _, rec := range records {
-			if rec.RecordType == DependencyResponseRecordType && rec.ExecutionContext == cc && rec.Uri == originalUrl && rec.ScopedSequence == seq {
-				depRes = rec
-				break
-			}
+	allowed, err := replayAllowed(r, rc, cc)
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
 		}
+		return
+	}
+	if !allowed || !forwardAllowed(r, dc, config) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
 
-		for _, rec := range records {
-			if rec.RecordType == RequestRecordType && rec.ExecutionContext == depRes.DependencyContext {
-				depInReq = rec
-				break
-			}
+	var depRes, depInReq Record
+	depRes, err = findFirst(rc, RecordKey{RecordType: DependencyResponseRecordType, ExecutionContext: cc, Uri: originalUrl, ScopedSequence: seq})
+	if err == nil && depRes.DependencyContext != "" {
+		depInReq, err = findFirst(rc, RecordKey{RecordType: RequestRecordType, ExecutionContext: depRes.DependencyContext})
+	}
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
+		}
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		oErr = err
+		return
+	}
+
+	gsq := dependencySequence(r)
+	recorded, err := recordedCall(rc, cc, originalUrl, seq, gsq)
+	if err != nil {
+		oErr = err
+		return
+	}
+
+	session := r.Header.Get(ReplaySessionHeader)
+	event := SessionEvent{
+		ExecutionContext: cc,
+		Caller:           recorded.ServiceName,
+		ServiceName:      depInReq.ServiceName,
+		Method:           r.Method,
+		Uri:              originalUrl,
+		ScopedSequence:   seq,
+	}
+
+	if events := detectDivergence(recorded, r.Method, originalUrl, body); len(events) > 0 {
+		for i := range events {
+			events[i].Time = time.Now()
+			events[i].RequestContext, events[i].ExecutionContext, events[i].ServiceName = rc, cc, recorded.ServiceName
+			events[i].Uri, events[i].DepencencySequence, events[i].ScopedSequence = originalUrl, gsq, seq
 		}
+		divergences.Add(events...)
+		event.Divergence = divergenceFields(events)
+		w.Header().Set(DivergenceHeader, event.Divergence)
 
-		if host, ok := mapping[strings.ToLower(depInReq.ServiceName)]; ok {
-			// forward request
-			reqUrl, err := url.Parse(originalUrl)
+		if config.divergence == divergenceFail {
+			data, err := json.Marshal(events)
 			if err != nil {
 				oErr = err
 				return
 			}
-			reqUrl.Host = host
-			if strings.HasPrefix(host, "localhost") {
-				reqUrl.Scheme = "http"
-			} else {
-				reqUrl.Scheme = "https"
+			event.Kind, event.StatusCode = RejectedEvent, http.StatusConflict
+			sessions.Add(session, rc, event)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusConflict)
+			w.Write(data)
+			return
+		}
+	}
+
+	host, mapped := config.hosts[strings.ToLower(depInReq.ServiceName)]
+	if mapped && depInReq.ServiceName != "" {
+		// forward request
+		reqUrl, err := url.Parse(originalUrl)
+		if err != nil {
+			oErr = err
+			return
+		}
+		reqUrl.Host = host
+		if strings.HasPrefix(host, "localhost") {
+			reqUrl.Scheme = "http"
+		} else {
+			reqUrl.Scheme = "https"
+		}
+
+		req, err := http.NewRequest(r.Method, reqUrl.String(), bytes.NewReader(body))
+		if err != nil {
+			oErr = err
+			return
+		}
+
+		for name, val := range depInReq.Header {
+			if len(val) > 0 {
+				req.Header.Add(name, val[0])
 			}
+		}
+
+		req.Header.Set(RequestContextHeader, depInReq.RequestContext)
+		req.Header.Set(CauseContextHeader, depInReq.CauseContext)
+		req.Header.Set(ExecutionContextHeader, depInReq.ExecutionContext)
+		req.Header.Set(ServiceDebugHeader, DebugEnabled)
+		req.Header.Set(DebugConfigHeader, dc)
+		if session != "" {
+			req.Header.Set(ReplaySessionHeader, session)
+		}
+		if replay := r.Header.Get(ReplayContextHeader); replay != "" {
+			req.Header.Set(ReplayContextHeader, replay)
+		}
+
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		event.Kind, event.Duration = ForwardEvent, time.Since(start).Milliseconds()
+		if err != nil {
+			event.Detail = err.Error()
+			sessions.Add(session, rc, event)
+			oErr = err
+			return
+		}
+		event.StatusCode = resp.StatusCode
+		sessions.Add(session, rc, event)
+
+		// Tells the SDK which execution served the call, a recorded replay links its tree with it
+		resp.Header.Set(ExecutionContextHeader, depInReq.ExecutionContext)
+		oErr = relayResponse(w, resp)
 
-			req, err := http.NewRequest(r.Method, reqUrl.String(), r.Body)
+	} else if depRes.RecordType == "" {
+		// The capture has no response for the call
+		event.Detail = fmt.Sprintf("no snapshot of %s [%d]", originalUrl, seq)
+
+		switch config.missing {
+		case missingLive:
+			req, err := http.NewRequest(r.Method, originalUrl, bytes.NewReader(body))
 			if err != nil {
 				oErr = err
 				return
 			}
+			req.Header = liveHeader(r.Header)
 
-			for name, val := range depInReq.Header {
-				if len(val) > 0 {
-					req.Header.Add(name, val[0])
-				}
-			}
-
-			req.Header.Set(RequestContextHeader, depInReq.RequestContext)
-			req.Header.Set(CauseContextHeader, depInReq.CauseContext)
-			req.Header.Set(ExecutionContextHeader, depInReq.ExecutionContext)
-			req.Header.Set(ServiceDebugHeader, DebugEnabled)
-			req.Header.Set(DebugConfigHeader, dc)
-
+			start := time.Now()
 			resp, err := http.DefaultClient.Do(req)
+			event.Kind, event.Duration = ForwardEvent, time.Since(start).Milliseconds()
 			if err != nil {
+				event.Detail += ", " + err.Error()
+				sessions.Add(session, rc, event)
 				oErr = err
 				return
 			}
+			event.StatusCode = resp.StatusCode
+			sessions.Add(session, rc, event)
+			oErr = relayResponse(w, resp)
+		case missingStub:
+			event.Kind, event.StatusCode = StubEvent, config.stub.status
+			sessions.Add(session, rc, event)
+			config.stub.write(w)
+		default:
+			event.Kind, event.StatusCode = MissingEvent, http.StatusBadGateway
+			sessions.Add(session, rc, event)
+			MissingSnapshot{
+				Error:            fmt.Sprintf("no recorded response for %s %s [%d]", r.Method, originalUrl, seq),
+				RequestContext:   rc,
+				ExecutionContext: cc,
+				Method:           r.Method,
+				Uri:              originalUrl,
+				ScopedSequence:   seq,
+				Policy:           config.missing,
+			}.write(w)
+		}
 
-			for name, val := range resp.Header {
-				if len(val) > 0 {
-					w.Header().Add(name, val[0])
-				}
+	} else {
+		// Forward snapshot
+		event.Kind, event.StatusCode, event.Duration = SnapshotEvent, depRes.StatusCode, depRes.Duration
+		status, body, transportErr := depRes.StatusCode, depRes.Body, depRes.TransportError
+
+		delay := config.latency.delay(depInReq.ServiceName, originalUrl, depRes.Duration)
+		if delay > 0 {
+			event.Detail = fmt.Sprintf("delayed %dms", delay.Milliseconds())
+		}
+
+		// Faults of the replay perturb the snapshot, a forced status turns a recorded transport error into a response
+		f := config.faults.dependency(depInReq.ServiceName, originalUrl, seq)
+		if f != nil {
+			event.Detail = strings.TrimSpace(event.Detail + " " + f.describe())
+			delay += f.delay
+			if f.status != 0 {
+				status, transportErr = f.status, ""
 			}
-			w.WriteHeader(resp.StatusCode)
-			if resp.ContentLength > 0 {
-				body, err := io.ReadAll(resp.Body)
-				if err != nil {
-					oErr = err
-					return
-				}
-				w.Write(body)
+			if f.err != "" {
+				transportErr = f.err + ": injected " + f.err + " fault"
 			}
-
-		} else {
-			// Forward snapshot
-			for name, val := range depRes.Header {
-				if len(val) > 0 {
-					w.Header().Add(name, val[0])
-				}
+			if mutated, err := f.mutate(body); err != nil {
+				event.Detail += ", set failed: " + err.Error()
+			} else {
+				body = mutated
 			}
-			w.WriteHeader(depRes.StatusCode)
-			if len(depRes.Body) > 0 {
-				w.Write(depRes.Body)
+		}
+
+		if !sleep(r, delay) {
+			return
+		}
+		if transportErr != "" {
+			// The call fails without a response, the SDK returns the error to the service
+			event.StatusCode = 0
+			event.Detail = strings.TrimSpace(event.Detail + " transport error " + transportErr)
+			sessions.Add(session, rc, event)
+			w.Header().Set(TransportErrorHeader, transportErr)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		event.StatusCode = status
+		sessions.Add(session, rc, event)
+
+		for name, val := range depRes.Header {
+			if len(val) > 0 {
+				w.Header().Add(name, val[0])
 			}
 		}
-	} else {
-		w.WriteHeader(http.StatusNotFound)
+		if f != nil && len(f.set) > 0 {
+			w.Header().Del("Content-Length")
+		}
+		w.WriteHeader(status)
+		if len(body) > 0 {
+			w.Write(body)
+		}
 	}
 }
 
+// findFirst returns the first record of the key, or a zero Record when there is none
+func findFirst(rc string, key RecordKey) (Record, error) {
+	records, err := store.Find(rc, key)
+	if err != nil || len(records) == 0 {
+		return Record{}, err
+	}
+	return records[0], nil
+}
+
 func parseDebugConfig(config string) map[string]string {
 	shs := strings.Split(config, "|")
 
@@ -381,6 +761,7 @@ func parseDebugConfig(config string) map[string]string {
 type ObservationData struct {
 	Body             []byte `json:"bd"`
 	ObservationError []byte `json:"oe"`
+	Override         bool   `json:"ov,omitempty"` // Body is a JSON value from the debug config instead of the recorded encoding
 }
 
 type Observations struct {
@@ -410,36 +791,109 @@ func observationHandler(w http.ResponseWriter, r *http.Request) {
 	rc := r.Header.Get(RequestContextHeader)
 	dc := r.Header.Get(DebugConfigHeader)
 
-	mapping := parseDebugConfig(dc)
+	config, err := loadReplayConfig(dc)
+	if err != nil {
+		badRequest = true
+		fmt.Printf("ERROR: %s\n", err.Error())
+		return
+	}
 
-	rwMux.RLock()
-	defer rwMux.RUnlock()
-	if records, ok := data[rc]; ok {
+	records, err := store.Get(rc)
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
+		}
+		return
+	}
+
+	obs := Observations{
+		Data: make(map[string]map[int]ObservationData, len(records)),
+	}
 
-		obs := Observations{
-			Data: make(map[string]map[int]ObservationData, len(records)),
+	served, passed, faulted, overridden := 0, make([]string, 0), make([]string, 0), make([]string, 0)
+	serve := func(mappingKey, name string, seq int, data ObservationData) {
+		if _, ok := obs.Data[name]; !ok {
+			obs.Data[name] = make(map[int]ObservationData)
 		}
+		if f := config.faults.observation(mappingKey, seq); f != nil {
+			data = f.observe(data)
+			if !slices.Contains(faulted, mappingKey) {
+				faulted = append(faulted, mappingKey)
+			}
+		}
+		if data.Override && !slices.Contains(overridden, mappingKey) {
+			overridden = append(overridden, mappingKey)
+		}
+		obs.Data[name][seq] = data
+		served++
+	}
 
-		for _, rec := range records {
-			if rec.RecordType == ObservedRecordType {
-				mappingKey := strings.ToLower(rec.ServiceName + ":" + rec.ObservationName)
-				if mapped, ok := mapping[mappingKey]; !(ok && mapped == "pass") {
-					if _, ok := obs.Data[rec.ObservationName]; !ok {
-						obs.Data[rec.ObservationName] = make(map[int]ObservationData)
-					}
-					obs.Data[rec.ObservationName][rec.ScopedSequence] = ObservationData{Body: rec.Body, ObservationError: rec.ObservationError}
+	// Ingest tokens only get the observations of their own service
+	service := authorizedService(r)
+	ownService := func(mappingKey string) bool {
+		name, _, _ := strings.Cut(mappingKey, ":")
+		return service == "" || strings.EqualFold(name, service)
+	}
+
+	names := make(map[string]string) // Recorded observation names by mapping key
+	for _, rec := range records {
+		if rec.RecordType == ObservedRecordType && ownService(strings.ToLower(rec.ServiceName)) {
+			mappingKey := strings.ToLower(rec.ServiceName + ":" + rec.ObservationName)
+			names[mappingKey] = rec.ObservationName
+			if o, ok := config.overrides[mappingKey]; ok {
+				if data, ok := o.value(rec.ScopedSequence); ok {
+					serve(mappingKey, rec.ObservationName, rec.ScopedSequence, data)
+					continue
 				}
 			}
+			if !config.pass[mappingKey] {
+				serve(mappingKey, rec.ObservationName, rec.ScopedSequence, ObservationData{Body: rec.Body, ObservationError: rec.ObservationError})
+			} else if !slices.Contains(passed, mappingKey) {
+				passed = append(passed, mappingKey)
+			}
 		}
+	}
 
-		data, err := json.Marshal(obs)
-		if err != nil {
-			oErr = err
-			return
+	// Overrides of values the capture doesn't have, for observations made more often by the replayed code
+	for mappingKey, o := range config.overrides {
+		if !ownService(mappingKey) {
+			continue
 		}
+		name, ok := names[mappingKey]
+		if !ok {
+			name = o.name
+		}
+		for seq := range o.sequences {
+			if _, ok := obs.Data[name][seq]; !ok {
+				data, _ := o.value(seq)
+				serve(mappingKey, name, seq, data)
+			}
+		}
+	}
 
-		w.Write(data)
-	} else {
-		w.WriteHeader(http.StatusNotFound)
+	detail := fmt.Sprintf("%d values served", served)
+	if len(passed) > 0 {
+		detail += ", passed " + strings.Join(passed, ", ")
+	}
+	if len(faulted) > 0 {
+		detail += ", faults on " + strings.Join(faulted, ", ")
+	}
+	if len(overridden) > 0 {
+		detail += ", overrides on " + strings.Join(overridden, ", ")
 	}
+	sessions.Add(r.Header.Get(ReplaySessionHeader), rc, SessionEvent{
+		Kind:             ObservationsEvent,
+		ExecutionContext: r.Header.Get(ExecutionContextHeader),
+		Detail:           detail,
+	})
+
+	data, err := json.Marshal(obs)
+	if err != nil {
+		oErr = err
+		return
+	}
+
+	w.Write(data)
 }
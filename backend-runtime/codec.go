@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// RecordStreamContentType is the binary ingestion format. A stream starts with the magic
+// "RREC" and a version byte, followed by records each prefixed with their length as an uvarint.
+// Bodies are written as raw bytes instead of base64, and records are decoded one at a time.
+const (
+	RecordStreamContentType = "application/x-record-stream"
+
+	recordStreamMagic   = "RREC"
+	recordStreamVersion = 1
+
+	maxFrameSize = 64 << 20
+)
+
+var errFrameTooLarge = errors.New("record frame too large")
+
+// RecordWriter encodes records into a record stream
+type RecordWriter struct {
+	w       io.Writer
+	started bool
+	frame   []byte
+}
+
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return &RecordWriter{w: w}
+}
+
+func (rw *RecordWriter) Write(rec *Record) error {
+	if !rw.started {
+		if _, err := rw.w.Write(append([]byte(recordStreamMagic), recordStreamVersion)); err != nil {
+			return err
+		}
+		rw.started = true
+	}
+
+	rw.frame = appendRecord(rw.frame[:0], rec)
+	prefix := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64), uint64(len(rw.frame)))
+	if _, err := rw.w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := rw.w.Write(rw.frame)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// The field order is the wire format, fields can only be added at the end with a new version
+func appendRecord(b []byte, rec *Record) []byte {
+	for _, s := range []string{rec.RequestContext, rec.CauseContext, rec.ExecutionContext, rec.DependencyContext,
+		string(rec.RecordType), rec.Method, rec.ServiceName, rec.ObservationName, rec.Host, rec.Uri} {
+		b = appendString(b, s)
+	}
+
+	b = binary.AppendVarint(b, rec.Time.Unix())
+	b = binary.AppendUvarint(b, uint64(rec.Time.Nanosecond()))
+	for _, n := range []int64{rec.Duration, int64(rec.DepencencySequence), int64(rec.ScopedSequence),
+		int64(rec.ObservationSequence), int64(rec.StatusCode)} {
+		b = binary.AppendVarint(b, n)
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(rec.Header)))
+	for name, vals := range rec.Header {
+		b = appendString(b, name)
+		b = binary.AppendUvarint(b, uint64(len(vals)))
+		for _, v := range vals {
+			b = appendString(b, v)
+		}
+	}
+
+	b = appendBytes(b, rec.Body)
+	b = appendBytes(b, rec.ObservationError)
+
+	b = binary.AppendUvarint(b, uint64(len(rec.Redacted)))
+	for _, path := range rec.Redacted {
+		b = appendString(b, path)
+	}
+	b = appendString(b, rec.TransportError)
+	return b
+}
+
+// RecordReader decodes a record stream one record at a time
+type RecordReader struct {
+	r       *bufio.Reader
+	started bool
+}
+
+func NewRecordReader(r io.Reader) *RecordReader {
+	return &RecordReader{r: bufio.NewReader(r)}
+}
+
+// Read returns io.EOF at the end of the stream
+func (rr *RecordReader) Read() (Record, error) {
+	if !rr.started {
+		header := make([]byte, len(recordStreamMagic)+1)
+		if _, err := io.ReadFull(rr.r, header); err != nil {
+			return Record{}, fmt.Errorf("reading stream header: %w", err)
+		}
+		if string(header[:len(recordStreamMagic)]) != recordStreamMagic {
+			return Record{}, fmt.Errorf("not a record stream")
+		}
+		if version := header[len(recordStreamMagic)]; version != recordStreamVersion {
+			return Record{}, fmt.Errorf("unsupported record stream version %d", version)
+		}
+		rr.started = true
+	}
+
+	size, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		// A clean end of stream is only possible between records
+		return Record{}, err
+	}
+	if size > maxFrameSize {
+		return Record{}, errFrameTooLarge
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(rr.r, frame); err != nil {
+		return Record{}, io.ErrUnexpectedEOF
+	}
+	return decodeRecord(frame)
+}
+
+type frameDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *frameDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *frameDecoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *frameDecoder) bytes() []byte {
+	size := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if size > uint64(len(d.b)) {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	if size == 0 {
+		return nil
+	}
+	v := d.b[:size:size]
+	d.b = d.b[size:]
+	return v
+}
+
+func (d *frameDecoder) string() string {
+	return string(d.bytes())
+}
+
+func decodeRecord(frame []byte) (Record, error) {
+	d := &frameDecoder{b: frame}
+	rec := Record{}
+
+	for _, s := range []*string{&rec.RequestContext, &rec.CauseContext, &rec.ExecutionContext, &rec.DependencyContext} {
+		*s = d.string()
+	}
+	rec.RecordType = RecordType(d.string())
+	for _, s := range []*string{&rec.Method, &rec.ServiceName, &rec.ObservationName, &rec.Host, &rec.Uri} {
+		*s = d.string()
+	}
+
+	sec := d.varint()
+	nsec := d.uvarint()
+	rec.Time = time.Unix(sec, int64(nsec)).UTC()
+
+	rec.Duration = d.varint()
+	rec.DepencencySequence = int(d.varint())
+	rec.ScopedSequence = int(d.varint())
+	rec.ObservationSequence = int(d.varint())
+	rec.StatusCode = int(d.varint())
+
+	// Every header entry takes at least two bytes, which bounds the count of a corrupt frame
+	if count := d.uvarint(); count > 0 && count <= uint64(len(d.b)) {
+		rec.Header = make(map[string][]string, count)
+		for range count {
+			name := d.string()
+			nvals := d.uvarint()
+			if nvals > uint64(len(d.b)) {
+				d.err = io.ErrUnexpectedEOF
+				break
+			}
+			vals := make([]string, nvals)
+			for i := range vals {
+				vals[i] = d.string()
+			}
+			rec.Header[name] = vals
+		}
+	} else if count > 0 {
+		d.err = io.ErrUnexpectedEOF
+	}
+
+	rec.Body = d.bytes()
+	rec.ObservationError = d.bytes()
+
+	if count := d.uvarint(); count > 0 && count <= uint64(len(d.b)) {
+		rec.Redacted = make([]string, count)
+		for i := range rec.Redacted {
+			rec.Redacted[i] = d.string()
+		}
+	} else if count > 0 {
+		d.err = io.ErrUnexpectedEOF
+	}
+	rec.TransportError = d.string()
+
+	if d.err != nil {
+		return Record{}, fmt.Errorf("corrupt record frame: %w", d.err)
+	}
+	return rec, nil
+}
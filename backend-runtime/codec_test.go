@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordStreamRoundTrip(t *testing.T) {
+	want := []Record{
+		{
+			RequestContext:     "rc",
+			CauseContext:       "cc",
+			ExecutionContext:   "ec",
+			RecordType:         DependencyResponseRecordType,
+			Method:             "GET",
+			Time:               time.Date(2024, 5, 1, 10, 0, 0, 42, time.UTC),
+			Duration:           1500,
+			DepencencySequence: 2,
+			ScopedSequence:     1,
+			ServiceName:        "ServiceA",
+			Uri:                "/api/count?n=1",
+			Header:             map[string][]string{"Content-Type": {"application/json"}, "Set-Cookie": {"a", "b"}},
+			Body:               []byte{0, 1, 2, 255},
+			StatusCode:         200,
+			Redacted:           []string{"items.0.card"},
+		},
+		{RequestContext: "rc", RecordType: ObservedRecordType, ObservationSequence: -1, ObservationError: []byte("failed")},
+		{RequestContext: "rc", RecordType: DependencyResponseRecordType, TransportError: "refused: connection refused"},
+	}
+
+	buffer := &bytes.Buffer{}
+	rw := NewRecordWriter(buffer)
+	for i := range want {
+		if err := rw.Write(&want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr := NewRecordReader(buffer)
+	for i := range want {
+		act, err := rr.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(want[i], act) {
+			t.Errorf("Want %v Actual %v\n", want[i], act)
+		}
+	}
+	if _, err := rr.Read(); err != io.EOF {
+		t.Errorf("Want %v Actual %v\n", io.EOF, err)
+	}
+}
+
+func TestRecordStreamTruncated(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	NewRecordWriter(buffer).Write(&Record{RequestContext: "rc", Body: []byte("body")})
+
+	data := buffer.Bytes()
+	if _, err := NewRecordReader(bytes.NewReader(data[:len(data)-2])).Read(); err == nil || err == io.EOF {
+		t.Errorf("Want %v Actual %v\n", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestRecordHandlerStream(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	data := streamOf(testRecords("a", 300))
+	r := httptest.NewRequest("POST", "/runtime/record", bytes.NewReader(data))
+	r.Header.Set("Content-Type", RecordStreamContentType)
+	w := httptest.NewRecorder()
+	recordHandler(w, r)
+
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("Want %v Actual %v\n", http.StatusAccepted, w.Code)
+	}
+	checkSequence(t, store, "a", 300)
+
+	// Nothing of a stream cut short is stored, or of one with a record of another service
+	serviceRecords := testRecords("b", 5)
+	for i := range serviceRecords {
+		serviceRecords[i].ServiceName = "ServiceA"
+	}
+	for _, c := range []struct {
+		stream []byte
+		token  string
+		status int
+	}{
+		{data[:len(data)-2], "", http.StatusBadRequest},
+		{streamOf(append(serviceRecords, Record{RequestContext: "b", ServiceName: "ServiceB"})), "service-a", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest("POST", "/runtime/record", bytes.NewReader(c.stream))
+		r.Header.Set("Content-Type", RecordStreamContentType)
+		if c.token != "" {
+			r = r.WithContext(context.WithValue(r.Context(), principalKey{}, Token{Token: c.token, Role: IngestRole, Service: "ServiceA"}))
+		}
+		w := httptest.NewRecorder()
+		recordHandler(w, r)
+		if w.Code != c.status {
+			t.Errorf("Want %v Actual %v\n", c.status, w.Code)
+		}
+	}
+	if _, err := store.Get("b"); err != ErrNotFound {
+		t.Errorf("Want %v Actual %v\n", ErrNotFound, err)
+	}
+	checkSequence(t, store, "a", 300)
+}
+
+func streamOf(records []Record) []byte {
+	buffer := &bytes.Buffer{}
+	rw := NewRecordWriter(buffer)
+	for i := range records {
+		rw.Write(&records[i])
+	}
+	return buffer.Bytes()
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DivergenceHeader lists the fields of a replayed dependency call that differ from the capture
+	DivergenceHeader = "X-Replay-Divergence"
+
+	// Divergence of a replay plan refusing diverging calls, the snapshot is served by default
+	divergenceFail = "fail"
+
+	maxDivergenceContexts = 1000
+	maxDivergences        = 1000
+)
+
+// Divergence is emitted when a service makes a dependency call during replay that differs from the recorded call
+type Divergence struct {
+	Time               time.Time `json:"tm"`
+	RequestContext     string    `json:"rc"`
+	ExecutionContext   string    `json:"ec"` // Execution that made the call
+	ServiceName        string    `json:"sn"` // Service that made the call
+	Uri                string    `json:"ru"`
+	DepencencySequence int       `json:"dq"`
+	ScopedSequence     int       `json:"sq"`
+	Field              string    `json:"field"` // call, method, uri or body
+	Recorded           string    `json:"recorded"`
+	Replayed           string    `json:"replayed"`
+}
+
+// divergenceLog keeps the latest divergences of recent request contexts
+type divergenceLog struct {
+	mu     sync.Mutex
+	events map[string][]Divergence
+	order  []string
+}
+
+var divergences = &divergenceLog{events: make(map[string][]Divergence)}
+
+func (l *divergenceLog) Add(events ...Divergence) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, d := range events {
+		// The values hold captured payloads, they're only served by the divergences endpoint
+		fmt.Printf("DIVERGENCE: rc=%s ec=%s service=%s uri=%s sequence=%d field=%s\n",
+			d.RequestContext, d.ExecutionContext, d.ServiceName, d.Uri, d.ScopedSequence, d.Field)
+
+		if _, ok := l.events[d.RequestContext]; !ok {
+			if len(l.order) >= maxDivergenceContexts {
+				delete(l.events, l.order[0])
+				l.order = l.order[1:]
+			}
+			l.order = append(l.order, d.RequestContext)
+		}
+		rcEvents := append(l.events[d.RequestContext], d)
+		if len(rcEvents) > maxDivergences {
+			rcEvents = rcEvents[len(rcEvents)-maxDivergences:]
+		}
+		l.events[d.RequestContext] = rcEvents
+	}
+}
+
+// Get returns the divergences of a request context that happened after since
+func (l *divergenceLog) Get(rc string, since time.Time) []Divergence {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	retval := make([]Divergence, 0)
+	for _, d := range l.events[rc] {
+		if d.Time.After(since) {
+			retval = append(retval, d)
+		}
+	}
+	return retval
+}
+
+// detectDivergence compares a replayed call with the recorded dependency request, which is the zero Record
+// when the call wasn't made in the capture. Recorded values redacted by the SDK match anything.
+func detectDivergence(recorded Record, method, uri string, body []byte) []Divergence {
+	if recorded.RecordType == "" {
+		return []Divergence{{Field: "call", Recorded: "<none>", Replayed: method + " " + uri}}
+	}
+
+	retval := make([]Divergence, 0)
+	if !strings.EqualFold(recorded.Method, method) {
+		retval = append(retval, Divergence{Field: "method", Recorded: recorded.Method, Replayed: method})
+	}
+	if recorded.Uri != uri {
+		retval = append(retval, Divergence{Field: "uri", Recorded: recorded.Uri, Replayed: uri})
+	}
+	if !bodiesMatch(recorded.Body, body, recorded.Redacted) {
+		retval = append(retval, Divergence{Field: "body", Recorded: preview(recorded.Body), Replayed: preview(body)})
+	}
+	return retval
+}
+
+// bodiesMatch compares a recorded body with a replayed one. Values the SDK redacted, at the
+// redacted paths or as tokens within strings, match whatever the replayed value is.
+func bodiesMatch(recorded, replayed []byte, redacted []string) bool {
+	recorded, replayed = bytes.TrimSpace(recorded), bytes.TrimSpace(replayed)
+	if bytes.Equal(recorded, replayed) {
+		return true
+	}
+
+	var recordedValue, replayedValue any
+	if json.Unmarshal(recorded, &recordedValue) != nil || json.Unmarshal(replayed, &replayedValue) != nil {
+		return redactedMatch(string(recorded), string(replayed))
+	}
+
+	skip := make(map[string]bool, len(redacted))
+	for _, path := range redacted {
+		skip[path] = true
+	}
+	return jsonMatch(recordedValue, replayedValue, "", skip)
+}
+
+func jsonMatch(recorded, replayed any, path string, redacted map[string]bool) bool {
+	if redacted[path] {
+		return true
+	}
+
+	switch r := recorded.(type) {
+	case string:
+		if strings.HasPrefix(r, "redacted:") {
+			return true
+		}
+		p, ok := replayed.(string)
+		return ok && redactedMatch(r, p)
+	case map[string]any:
+		p, ok := replayed.(map[string]any)
+		if !ok || len(r) != len(p) {
+			return false
+		}
+		for k, v := range r {
+			if pv, ok := p[k]; !ok || !jsonMatch(v, pv, joinPath(path, k), redacted) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		p, ok := replayed.([]any)
+		if !ok || len(r) != len(p) {
+			return false
+		}
+		for i := range r {
+			if !jsonMatch(r[i], p[i], joinPath(path, strconv.Itoa(i)), redacted) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(recorded, replayed)
+}
+
+// joinPath builds the paths the SDK reports redacted values at, like items.0.card
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// redactionToken is what the SDK replaces a redacted value with
+var redactionToken = regexp.MustCompile(`redacted:[0-9a-f]{24}`)
+
+// redactedMatch compares recorded text with the replayed text, a redaction token in the
+// recorded text stands for any text, like a scrubbed card number within a message
+func redactedMatch(recorded, replayed string) bool {
+	parts := redactionToken.Split(recorded, -1)
+	if len(parts) == 1 {
+		return recorded == replayed
+	}
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	matched, _ := regexp.MatchString(`(?s)^`+strings.Join(parts, ".*")+`$`, replayed)
+	return matched
+}
+
+func preview(data []byte) string {
+	if len(data) > 256 {
+		return string(data[:253]) + "..."
+	}
+	return string(data)
+}
+
+// recordedCall finds the dependency request a replayed call corresponds to. Calls are matched by URI and
+// scoped sequence, a call whose URI changed is found by its position among the calls of the execution.
+func recordedCall(rc, cc, uri string, seq, gsq int) (Record, error) {
+	call, err := findFirst(rc, RecordKey{RecordType: DependencyRequestRecordType, ExecutionContext: cc, Uri: uri, ScopedSequence: seq})
+	if err != nil || call.RecordType != "" || gsq < 0 {
+		return call, err
+	}
+
+	// Only diverging calls get here, so the slow path is fine
+	records, err := store.Get(rc)
+	if err != nil {
+		return Record{}, err
+	}
+	for _, rec := range records {
+		if rec.RecordType == DependencyRequestRecordType && rec.ExecutionContext == cc && rec.DepencencySequence == gsq {
+			return rec, nil
+		}
+	}
+	return Record{}, nil
+}
+
+func divergenceFields(events []Divergence) string {
+	fields := make([]string, len(events))
+	for i := range events {
+		fields[i] = events[i].Field
+	}
+	return strings.Join(fields, ",")
+}
+
+func divergenceHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		badRequest bool
+		oErr       error
+	)
+
+	defer func() {
+		if badRequest {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if oErr != nil {
+			fmt.Printf("ERROR: %s\n", oErr.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	if r.Method != "GET" {
+		badRequest = true
+		return
+	}
+
+	queries := r.URL.Query()
+	rc := queries.Get("rc")
+	if rc == "" {
+		badRequest = true
+		return
+	}
+
+	since := time.Time{}
+	if s := queries.Get("since"); s != "" {
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			badRequest = true
+			return
+		}
+		since = t
+	}
+
+	body, err := json.Marshal(divergences.Get(rc, since))
+	if err != nil {
+		oErr = err
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, oErr = w.Write(body)
+}
+
+// dependencySequence reads the global dependency sequence sent by the SDK, -1 when it's missing
+func dependencySequence(r *http.Request) int {
+	gsq, err := strconv.Atoi(r.Header.Get(DepencencySequenceHeader))
+	if err != nil {
+		return -1
+	}
+	return gsq
+}
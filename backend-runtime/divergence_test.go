@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func proxyRequest(rc, cc, uri, body, config string, seq string) *http.Request {
+	r := httptest.NewRequest("POST", "/runtime/proxy?ref="+url.QueryEscape(uri), strings.NewReader(body))
+	r.Header.Set(RequestContextHeader, rc)
+	r.Header.Set(CauseContextHeader, cc)
+	r.Header.Set(ScopedDependencySequenceHeader, seq)
+	r.Header.Set(DepencencySequenceHeader, seq)
+	r.Header.Set(DebugConfigHeader, config)
+	return r
+}
+
+func TestProxyDivergence(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count?n=1", Body: []byte(`{"a": 1, "token": "redacted:abc"}`)},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count?n=1", StatusCode: 201},
+	})
+	start := time.Now()
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count?n=1", `{"token": "secret", "a": 1}`, "", "0"))
+	if w.Code != http.StatusCreated || w.Header().Get(DivergenceHeader) != "" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusCreated, w.Code, w.Header().Get(DivergenceHeader))
+	}
+
+	r := proxyRequest("rc", "e1", "http://b/count?n=1", `{"a": 2}`, "", "0")
+	r.Method = "PUT"
+	w = httptest.NewRecorder()
+	proxyHandler(w, r)
+	if act := w.Header().Get(DivergenceHeader); act != "method,body" {
+		t.Errorf("Want %v Actual %v\n", "method,body", act)
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/other", "", inlinePlan(`{"version": 1, "divergence": "fail"}`), "1"))
+	if w.Code != http.StatusConflict || w.Header().Get(DivergenceHeader) != "call" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusConflict, w.Code, w.Header().Get(DivergenceHeader))
+	}
+
+	events := divergences.Get("rc", start)
+	if len(events) != 3 || events[0].ServiceName != "ServiceA" || events[2].Field != "call" {
+		t.Errorf("Want %v Actual %v\n", 3, events)
+	}
+}
+
+func TestProxyRedactedBody(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	// The SDK replaced the card number with a number and the card in the note with a token
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/pay",
+			Body: []byte(`{"card": 801246913, "note": "paid with redacted:0123456789abcdef01234567 today"}`), Redacted: []string{"card"}},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/pay", StatusCode: 201},
+	})
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/pay", `{"card": 4111111111111111, "note": "paid with 4111-1111 today"}`, inlinePlan(`{"version": 1, "divergence": "fail"}`), "0"))
+	if w.Code != http.StatusCreated || w.Header().Get(DivergenceHeader) != "" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusCreated, w.Code, w.Header().Get(DivergenceHeader))
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/pay", `{"card": 4111111111111111, "note": "paid with 4111-1111 yesterday"}`, inlinePlan(`{"version": 1, "divergence": "fail"}`), "0"))
+	if w.Code != http.StatusConflict || w.Header().Get(DivergenceHeader) != "body" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusConflict, w.Code, w.Header().Get(DivergenceHeader))
+	}
+}
+
+func TestDivergenceLogOmitsValues(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	divergences.Add(Divergence{RequestContext: "rc-log", ServiceName: "ServiceA", Uri: "http://b/pay", Field: "body", Recorded: "card 4111", Replayed: "card 5500"})
+	os.Stdout = stdout
+	w.Close()
+
+	data, _ := io.ReadAll(r)
+	if act := string(data); !strings.Contains(act, "rc=rc-log") || strings.Contains(act, "card") {
+		t.Errorf("Want %v Actual %v\n", "metadata only", act)
+	}
+}
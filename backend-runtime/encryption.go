@@ -0,0 +1,336 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// keyFile is the on disk format of the keyring, keys are base64 encoded 32 byte AES keys.
+// New records are encrypted with the active key, the others are kept to decrypt older records.
+type keyFile struct {
+	Active string            `json:"active"`
+	Keys   map[string]string `json:"keys"`
+}
+
+type Keyring struct {
+	path   string
+	mu     sync.RWMutex
+	active string
+	keys   map[string][]byte
+	err    error // Why the keys are unavailable
+}
+
+// LoadKeyring never fails, a keyring that can't be loaded reports ErrKeyUnavailable until it's reloaded
+func LoadKeyring(path string) *Keyring {
+	k := &Keyring{path: path}
+	k.Reload()
+	return k
+}
+
+func readKeyFile(path string) (keyFile, error) {
+	kf := keyFile{Keys: map[string]string{}}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return kf, err
+	}
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return kf, fmt.Errorf("%s: %w", path, err)
+	}
+	return kf, nil
+}
+
+func (k *Keyring) Reload() {
+	keys := make(map[string][]byte)
+	kf, err := readKeyFile(k.path)
+
+	if err == nil {
+		for id, encoded := range kf.Keys {
+			key, decErr := base64.StdEncoding.DecodeString(encoded)
+			if decErr != nil || len(key) != 32 {
+				err = fmt.Errorf("%s: key %s is not a base64 encoded 32 byte key", k.path, id)
+				break
+			}
+			keys[id] = key
+		}
+	}
+	if err == nil {
+		if _, ok := keys[kf.Active]; !ok {
+			err = fmt.Errorf("%s: active key %q not found", k.path, kf.Active)
+		}
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err != nil {
+		fmt.Printf("ERROR: encryption keys unavailable: %s\n", err.Error())
+		k.active, k.keys, k.err = "", nil, err
+		return
+	}
+	k.active, k.keys, k.err = kf.Active, keys, nil
+}
+
+func (k *Keyring) activeKey() (string, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.err != nil {
+		return "", nil, fmt.Errorf("%w: %s", ErrKeyUnavailable, k.err.Error())
+	}
+	return k.active, k.keys[k.active], nil
+}
+
+func (k *Keyring) key(id string) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	if k.err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrKeyUnavailable, k.err.Error())
+	}
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: key %q not found", ErrKeyUnavailable, id)
+	}
+	return key, nil
+}
+
+// reloadOnHangup reloads the keyring on SIGHUP, so a restored or rotated key file is picked up without a restart
+func reloadOnHangup(k *Keyring) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	for range signals {
+		k.Reload()
+	}
+}
+
+// AddKey generates a new key and makes it the active key of the key file, creating the file if needed
+func AddKey(path, id string) error {
+	kf, err := readKeyFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, ok := kf.Keys[id]; ok {
+		return fmt.Errorf("key %q already exists", id)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	kf.Keys[id] = base64.StdEncoding.EncodeToString(key)
+	kf.Active = id
+
+	data, err := json.MarshalIndent(kf, "", "    ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func sealData(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(plaintext)+gcm.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openData(key, sealed, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], aad)
+}
+
+// envelope is what an encrypted record keeps in Record.Envelope. The sensitive fields are
+// encrypted with a random data key, which is in turn encrypted with a key of the keyring.
+type envelope struct {
+	KeyID   string `json:"kid"`
+	DataKey []byte `json:"dk"`
+	Data    []byte `json:"d"`
+}
+
+type sensitiveFields struct {
+	Header           map[string][]string `json:"he"`
+	Body             []byte              `json:"bd"`
+	ObservationError []byte              `json:"oe"`
+	TransportError   string              `json:"te,omitempty"`
+}
+
+// encryptedStore encrypts the headers, bodies and errors of records before they reach the store
+type encryptedStore struct {
+	Store
+	keys *Keyring
+}
+
+func NewEncryptedStore(s Store, keys *Keyring) *encryptedStore {
+	return &encryptedStore{Store: s, keys: keys}
+}
+
+func (e *encryptedStore) encrypt(rec Record) (Record, error) {
+	id, key, err := e.keys.activeKey()
+	if err != nil {
+		return rec, err
+	}
+
+	plaintext, err := json.Marshal(sensitiveFields{Header: rec.Header, Body: rec.Body, ObservationError: rec.ObservationError, TransportError: rec.TransportError})
+	if err != nil {
+		return rec, err
+	}
+
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return rec, err
+	}
+
+	// Bound to the request context so an envelope can't be moved to another capture
+	aad := []byte(rec.RequestContext)
+	env := envelope{KeyID: id}
+	if env.DataKey, err = sealData(key, dataKey, aad); err != nil {
+		return rec, err
+	}
+	if env.Data, err = sealData(dataKey, plaintext, aad); err != nil {
+		return rec, err
+	}
+
+	if rec.Envelope, err = json.Marshal(env); err != nil {
+		return rec, err
+	}
+	rec.Header, rec.Body, rec.ObservationError, rec.TransportError = nil, nil, nil, ""
+	return rec, nil
+}
+
+func (e *encryptedStore) decrypt(rec Record) (Record, error) {
+	if len(rec.Envelope) == 0 {
+		// Captured before encryption was enabled
+		return rec, nil
+	}
+
+	env := envelope{}
+	if err := json.Unmarshal(rec.Envelope, &env); err != nil {
+		return rec, err
+	}
+	key, err := e.keys.key(env.KeyID)
+	if err != nil {
+		return rec, err
+	}
+
+	aad := []byte(rec.RequestContext)
+	dataKey, err := openData(key, env.DataKey, aad)
+	if err != nil {
+		return rec, fmt.Errorf("unable to decrypt data key: %w", err)
+	}
+	plaintext, err := openData(dataKey, env.Data, aad)
+	if err != nil {
+		return rec, fmt.Errorf("unable to decrypt record: %w", err)
+	}
+
+	fields := sensitiveFields{}
+	if err := json.Unmarshal(plaintext, &fields); err != nil {
+		return rec, err
+	}
+	rec.Header, rec.Body, rec.ObservationError, rec.TransportError = fields.Header, fields.Body, fields.ObservationError, fields.TransportError
+	rec.Envelope = nil
+	return rec, nil
+}
+
+func (e *encryptedStore) Append(records []Record) error {
+	encrypted := make([]Record, len(records))
+	for i := range records {
+		rec, err := e.encrypt(records[i])
+		if err != nil {
+			return err
+		}
+		encrypted[i] = rec
+	}
+	return e.Store.Append(encrypted)
+}
+
+func (e *encryptedStore) decryptAll(records []Record, err error) ([]Record, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range records {
+		if records[i], err = e.decrypt(records[i]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+func (e *encryptedStore) Get(rc string) ([]Record, error) {
+	return e.decryptAll(e.Store.Get(rc))
+}
+
+func (e *encryptedStore) Find(rc string, key RecordKey) ([]Record, error) {
+	return e.decryptAll(e.Store.Find(rc, key))
+}
+
+type rewriter interface {
+	Rewrite(transform func(Record) (Record, error)) error
+}
+
+// Reencrypt rewrites every stored record with the active key, so older keys can be retired
+func (e *encryptedStore) Reencrypt() error {
+	rw, ok := e.Store.(rewriter)
+	if !ok {
+		return fmt.Errorf("store doesn't support rewriting records")
+	}
+
+	activeID, _, err := e.keys.activeKey()
+	if err != nil {
+		return err
+	}
+
+	return rw.Rewrite(func(rec Record) (Record, error) {
+		if len(rec.Envelope) > 0 {
+			env := envelope{}
+			if err := json.Unmarshal(rec.Envelope, &env); err != nil {
+				return rec, err
+			}
+			if env.KeyID == activeID {
+				return rec, nil
+			}
+		}
+
+		rec, err := e.decrypt(rec)
+		if err != nil {
+			return rec, err
+		}
+		return e.encrypt(rec)
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEncryptedStore(t *testing.T) {
+	dir := t.TempDir()
+	keyPath := filepath.Join(dir, "keys.json")
+	if err := AddKey(keyPath, "k1"); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := OpenSegmentStore(filepath.Join(dir, "data"), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer segments.Close()
+
+	keys := LoadKeyring(keyPath)
+	s := NewEncryptedStore(segments, keys)
+
+	records := testRecords("a", 2)
+	records[0].Header = map[string][]string{"Authorization": {"secret"}}
+	records[1].TransportError = "refused: dial tcp 10.0.0.1:80"
+	if err := s.Append(records); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := segments.Get("a")
+	if len(raw[0].Envelope) == 0 || raw[0].Header != nil || raw[0].Body != nil || raw[1].TransportError != "" {
+		t.Errorf("Record stored unencrypted %+v", raw[0])
+	}
+
+	act, err := s.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act[0].Header["Authorization"][0] != "secret" || string(act[1].Body) != "body" || act[1].TransportError != records[1].TransportError {
+		t.Errorf("Want %+v Actual %+v\n", records, act)
+	}
+
+	// Rotate, re-encrypt and retire the old key
+	if err := AddKey(keyPath, "k2"); err != nil {
+		t.Fatal(err)
+	}
+	keys.Reload()
+	if err := s.Reencrypt(); err != nil {
+		t.Fatal(err)
+	}
+
+	kf, _ := readKeyFile(keyPath)
+	delete(kf.Keys, "k1")
+	data, _ := json.Marshal(kf)
+	os.WriteFile(keyPath, data, 0o600)
+	keys.Reload()
+
+	act, err = s.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(act[1].Body) != "body" {
+		t.Errorf("Want %v Actual %v\n", "body", string(act[1].Body))
+	}
+	raw, _ = segments.Get("a")
+	if !strings.Contains(string(raw[0].Envelope), `"kid":"k2"`) {
+		t.Errorf("Record not re-encrypted %s", raw[0].Envelope)
+	}
+
+	// Key file gone
+	os.Remove(keyPath)
+	keys.Reload()
+
+	if _, err := s.Get("a"); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("Want %v Actual %v\n", ErrKeyUnavailable, err)
+	}
+	if err := s.Append(testRecords("b", 1)); !errors.Is(err, ErrKeyUnavailable) {
+		t.Errorf("Want %v Actual %v\n", ErrKeyUnavailable, err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The faults of a replay plan perturb the snapshots of a replay. The target is a service or host for dependency
+// calls and service:observation for observations, both case insensitive, and is narrowed to one call or value by
+// a sequence. A dependency fault can force the status, hold the snapshot back on top of the recorded latency, fail
+// the call with a transport error or set JSON fields of the body, an observation fault can set fields or fail it.
+// Faults are kept by target, with #<sequence> appended for one call or value.
+const faultSequenceSeparator = "#"
+
+// Kinds of transport errors the SDK can return in place of a response
+var transportErrorKinds = []string{"timeout", "refused", "reset", "dns", "tls", "canceled", "other"}
+
+type fault struct {
+	status int
+	delay  time.Duration
+	err    string
+	set    map[string]any // New values by JSON path, like items.0.price
+}
+
+// faultConfig holds the faults by lower case target
+type faultConfig map[string]*fault
+
+func newFaultConfig(plans []FaultPlan) (faultConfig, error) {
+	config := make(faultConfig)
+
+	for _, p := range plans {
+		if p.Status == 0 && p.Delay == "" && p.Error == "" && len(p.Set) == 0 {
+			continue
+		}
+		name := strings.ToLower(p.Target)
+		if name == "" {
+			return nil, fmt.Errorf("missing target of fault")
+		}
+		target := name
+		if p.Sequence != nil {
+			if *p.Sequence < 0 {
+				return nil, fmt.Errorf("invalid sequence %d of fault %s", *p.Sequence, p.Target)
+			}
+			target += faultSequenceSeparator + strconv.Itoa(*p.Sequence)
+		}
+		observation := isObservationTarget(name)
+
+		f, ok := config[target]
+		if !ok {
+			f = &fault{set: make(map[string]any)}
+			config[target] = f
+		}
+
+		if p.Status != 0 {
+			if observation || p.Status < 100 || p.Status > 999 {
+				return nil, fmt.Errorf("invalid status %d of fault %s", p.Status, p.Target)
+			}
+			f.status = p.Status
+		}
+		if p.Delay != "" {
+			delay, err := time.ParseDuration(p.Delay)
+			if observation || err != nil || delay < 0 {
+				return nil, fmt.Errorf("invalid delay %q of fault %s", p.Delay, p.Target)
+			}
+			f.delay = delay
+		}
+		if p.Error != "" {
+			if !observation && !slices.Contains(transportErrorKinds, p.Error) {
+				return nil, fmt.Errorf("invalid error %q of fault %s, expected one of %s", p.Error, p.Target, strings.Join(transportErrorKinds, ", "))
+			}
+			f.err = p.Error
+		}
+		for path, value := range p.Set {
+			var v any
+			if err := json.Unmarshal(value, &v); path == "" || err != nil {
+				return nil, fmt.Errorf("invalid value of %s of fault %s", path, p.Target)
+			}
+			f.set[path] = v
+		}
+	}
+	return config, nil
+}
+
+// dependency returns the fault of the call to the service, or to the host of the uri for external dependencies,
+// a fault of the call's sequence goes before one of every call
+func (c faultConfig) dependency(service, uri string, seq int) *fault {
+	for _, target := range []string{strings.ToLower(service), hostOf(uri)} {
+		if target == "" {
+			continue
+		}
+		if f, ok := c[target+faultSequenceSeparator+strconv.Itoa(seq)]; ok {
+			return f
+		}
+		if f, ok := c[target]; ok {
+			return f
+		}
+	}
+	return nil
+}
+
+// observation returns the fault of the observed value, target is the lower case service:observation
+func (c faultConfig) observation(target string, seq int) *fault {
+	if f, ok := c[target+faultSequenceSeparator+strconv.Itoa(seq)]; ok {
+		return f
+	}
+	return c[target]
+}
+
+// isObservationTarget tells service:observation apart from a host with a port
+func isObservationTarget(target string) bool {
+	_, port, ok := strings.Cut(target, ":")
+	if !ok {
+		return false
+	}
+	_, err := strconv.Atoi(port)
+	return err != nil
+}
+
+// mutate sets the JSON fields of the fault in body, it fails when the body isn't JSON or a path doesn't exist
+func (f *fault) mutate(body []byte) ([]byte, error) {
+	if len(f.set) == 0 {
+		return body, nil
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return body, fmt.Errorf("body isn't JSON")
+	}
+
+	// Sorted, so a path setting a whole object goes before paths within it
+	paths := make([]string, 0, len(f.set))
+	for path := range f.set {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	for _, path := range paths {
+		var err error
+		if value, err = setJSON(value, strings.Split(path, "."), f.set[path]); err != nil {
+			return body, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return json.Marshal(value)
+}
+
+func setJSON(value any, path []string, newValue any) (any, error) {
+	if len(path) == 0 {
+		return newValue, nil
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		child, ok := v[path[0]]
+		if !ok && len(path) > 1 {
+			return value, fmt.Errorf("no field %s", path[0])
+		}
+		updated, err := setJSON(child, path[1:], newValue)
+		if err != nil {
+			return value, err
+		}
+		v[path[0]] = updated
+		return v, nil
+	case []any:
+		i, err := strconv.Atoi(path[0])
+		if err != nil || i < 0 || i >= len(v) {
+			return value, fmt.Errorf("no element %s", path[0])
+		}
+		if v[i], err = setJSON(v[i], path[1:], newValue); err != nil {
+			return value, err
+		}
+		return v, nil
+	}
+	return value, fmt.Errorf("no field %s", path[0])
+}
+
+// describe lists the faults for the replay session
+func (f *fault) describe() string {
+	parts := make([]string, 0)
+	if f.status != 0 {
+		parts = append(parts, fmt.Sprintf("status %d", f.status))
+	}
+	if f.delay != 0 {
+		parts = append(parts, "delay "+f.delay.String())
+	}
+	if f.err != "" {
+		parts = append(parts, "error "+f.err)
+	}
+	for path := range f.set {
+		parts = append(parts, "set "+path)
+	}
+	slices.Sort(parts)
+	return "fault " + strings.Join(parts, ", ")
+}
+
+// observe applies the fault to an observed value, values that aren't JSON, like numbers, keep their body
+func (f *fault) observe(data ObservationData) ObservationData {
+	if body, err := f.mutate(data.Body); err == nil {
+		data.Body = body
+	}
+	if f.err != "" {
+		data.ObservationError = []byte(f.err)
+	}
+	return data
+}
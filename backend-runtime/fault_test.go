@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFaultMutate(t *testing.T) {
+	config, err := newFaultConfig([]FaultPlan{
+		{Target: "ServiceB", Set: map[string]json.RawMessage{"items.1.price": json.RawMessage(`0`)}},
+		{Target: "serviceb", Set: map[string]json.RawMessage{"name": json.RawMessage(`"x|y=z"`)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := config.dependency("ServiceB", "http://localhost:3001/stock", 0)
+	if f == nil {
+		t.Fatalf("Want %v Actual %v\n", "fault", f)
+	}
+	body, err := f.mutate([]byte(`{"items": [{"price": 1}, {"price": 2}], "name": "a"}`))
+	if act := string(body); err != nil || act != `{"items":[{"price":1},{"price":0}],"name":"x|y=z"}` {
+		t.Errorf("Want %v Actual %v %v\n", "mutated body", act, err)
+	}
+
+	for _, invalid := range []string{`not json`, `{"items": []}`} {
+		if _, err := f.mutate([]byte(invalid)); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+
+	two := 2
+	if _, err := newFaultConfig([]FaultPlan{{Target: "localhost:3001", Status: 503}, {Target: "b", Sequence: &two, Delay: "1s"}}); err != nil {
+		t.Errorf("Want %v Actual %v\n", nil, err)
+	}
+
+	negative := -1
+	for _, invalid := range []FaultPlan{{Target: "b", Sequence: &negative, Status: 503}, {Target: "b", Status: 42}, {Target: "b", Delay: "-1s"},
+		{Target: "b", Delay: "fast"}, {Target: "b", Error: "boom"}, {Target: "a:now", Status: 500}, {Status: 500}} {
+		if _, err := newFaultConfig([]FaultPlan{invalid}); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+}
+
+func TestProxyFault(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", StatusCode: 200,
+			Header: map[string][]string{"Content-Length": {"11"}}, Body: []byte(`{"count":1}`)},
+	})
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", inlinePlan(`{"version": 1, "faults": [{"target": "b", "status": 503, "set": {"count": 42}}]}`), "0"))
+	if act := w.Body.String(); w.Code != http.StatusServiceUnavailable || act != `{"count":42}` || w.Header().Get("Content-Length") != "" {
+		t.Errorf("Want %v Actual %v %v\n", http.StatusServiceUnavailable, w.Code, act)
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", inlinePlan(`{"version": 1, "faults": [{"target": "b", "error": "timeout"}]}`), "0"))
+	if act := w.Header().Get(TransportErrorHeader); w.Code != http.StatusBadGateway || act != "timeout: injected timeout fault" {
+		t.Errorf("Want %v Actual %v %v\n", "timeout", w.Code, act)
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", inlinePlan(`{"version": 1, "faults": [{"target": "b", "error": "boom"}]}`), "0"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Want %v Actual %v\n", http.StatusBadRequest, w.Code)
+	}
+}
+
+func TestObservationFault(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceC", ObservationName: "Config", Body: []byte(`{"limit":5}`)},
+	})
+
+	r := httptest.NewRequest("GET", "/runtime/observations", nil)
+	r.Header.Set(RequestContextHeader, "rc")
+	r.Header.Set(DebugConfigHeader, inlinePlan(`{"version": 1, "faults": [{"target": "serviceC:Config", "set": {"limit": 0}}, {"target": "servicec:config", "error": "unavailable"}]}`))
+	w := httptest.NewRecorder()
+	observationHandler(w, r)
+
+	var obs Observations
+	if err := json.Unmarshal(w.Body.Bytes(), &obs); err != nil {
+		t.Fatal(err)
+	}
+	data := obs.Data["Config"][0]
+	if string(data.Body) != `{"limit":0}` || string(data.ObservationError) != "unavailable" {
+		t.Errorf("Want %v Actual %s %s\n", "faulted observation", data.Body, data.ObservationError)
+	}
+}
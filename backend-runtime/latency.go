@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The latency section of a replay plan delays snapshots by their recorded duration times a factor, for every
+// dependency or for the calls to one service or host. Snapshots aren't delayed by default.
+type latencyConfig struct {
+	factor   float64            // Every dependency, 0 when not set
+	services map[string]float64 // Dependencies by lower case service name or host
+}
+
+func newLatencyConfig(plan *LatencyPlan) (latencyConfig, error) {
+	config := latencyConfig{services: make(map[string]float64)}
+	if plan == nil {
+		return config, nil
+	}
+
+	valid := func(factor float64) bool {
+		return factor >= 0 && !math.IsNaN(factor) && !math.IsInf(factor, 0)
+	}
+	if !valid(plan.Factor) {
+		return config, fmt.Errorf("invalid latency factor %v", plan.Factor)
+	}
+	config.factor = plan.Factor
+
+	for service, factor := range plan.Services {
+		if service == "" {
+			return config, fmt.Errorf("missing service of latency factor %v", factor)
+		}
+		if !valid(factor) {
+			return config, fmt.Errorf("invalid latency factor %v of %s", factor, service)
+		}
+		config.services[strings.ToLower(service)] = factor
+	}
+	return config, nil
+}
+
+// delay is how long the snapshot of a call to the service is held back, uri is used for external dependencies
+func (c latencyConfig) delay(service, uri string, recorded int64) time.Duration {
+	factor, ok := c.services[strings.ToLower(service)]
+	if !ok {
+		factor, ok = c.services[hostOf(uri)]
+	}
+	if !ok {
+		factor = c.factor
+	}
+	return time.Duration(float64(recorded) * factor * float64(time.Millisecond))
+}
+
+func hostOf(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// sleep waits for d unless the caller gives up first, which tells if the snapshot should still be written
+func sleep(r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-r.Context().Done():
+		return false
+	}
+}
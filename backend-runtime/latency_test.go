@@ -0,0 +1,59 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLatencyDelay(t *testing.T) {
+	config, err := newLatencyConfig(&LatencyPlan{Factor: 1, Services: map[string]float64{"ServiceC": 0.5, "api.example.com": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		service, uri string
+		want         time.Duration
+	}{
+		{"ServiceB", "http://b/count", 100 * time.Millisecond},
+		{"servicec", "http://c/count", 50 * time.Millisecond},
+		{"", "https://API.example.com/v1", 200 * time.Millisecond},
+	}
+	for _, c := range cases {
+		if act := config.delay(c.service, c.uri, 100); act != c.want {
+			t.Errorf("Want %v Actual %v\n", c.want, act)
+		}
+	}
+
+	for _, invalid := range []LatencyPlan{{Factor: -1}, {Factor: math.NaN()}, {Services: map[string]float64{"": 1}}, {Services: map[string]float64{"b": math.Inf(1)}}} {
+		if _, err := newLatencyConfig(&invalid); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+}
+
+func TestProxyLatency(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", StatusCode: 200, Duration: 40},
+	})
+
+	start := time.Now()
+	proxyHandler(httptest.NewRecorder(), proxyRequest("rc", "e1", "http://b/count", "", "", "0"))
+	if elapsed := time.Since(start); elapsed >= 40*time.Millisecond {
+		t.Errorf("Want %v Actual %v\n", "no delay", elapsed)
+	}
+
+	start = time.Now()
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", inlinePlan(`{"version": 1, "latency": {"factor": 1.5}}`), "0"))
+	if elapsed := time.Since(start); w.Code != http.StatusOK || elapsed < 60*time.Millisecond {
+		t.Errorf("Want %v Actual %v %v\n", 60*time.Millisecond, w.Code, elapsed)
+	}
+}
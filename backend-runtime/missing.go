@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Policies of the missing section of a replay plan, deciding what happens to a call without a recorded response
+const (
+	missingFail = "fail" // Answer with 502 naming the missing call, the default
+	missingLive = "live" // Send the call to its original URL
+	missingStub = "stub" // Answer with the configured stub
+
+	captureHeader   = "X-Capture"
+	captureDisabled = "0"
+)
+
+// Headers only meant for the runtime, they aren't sent on when a call goes live
+var runtimeHeaders = []string{
+	RuntimeAuthorizationHeader,
+	RequestContextHeader,
+	CauseContextHeader,
+	ExecutionContextHeader,
+	ServiceDebugHeader,
+	DebugConfigHeader,
+	DepencencySequenceHeader,
+	ScopedDependencySequenceHeader,
+	ReplaySessionHeader,
+	ReplayContextHeader,
+}
+
+// MissingSnapshot describes a replayed call the capture has no response for
+type MissingSnapshot struct {
+	Error            string `json:"error"`
+	RequestContext   string `json:"rc"`
+	ExecutionContext string `json:"ec"` // Execution that made the call
+	Method           string `json:"rm"`
+	Uri              string `json:"ru"`
+	ScopedSequence   int    `json:"sq"`
+	Policy           string `json:"policy"`
+}
+
+type stubResponse struct {
+	status int
+	body   []byte
+}
+
+// missingPolicy reads the policy of the replay and the stub it serves
+func missingPolicy(plan *MissingPlan) (string, stubResponse, error) {
+	if plan == nil {
+		return missingFail, stubResponse{}, nil
+	}
+	switch plan.Policy {
+	case "":
+		return missingFail, stubResponse{}, nil
+	case missingFail, missingLive:
+		return plan.Policy, stubResponse{}, nil
+	case missingStub:
+	default:
+		return "", stubResponse{}, fmt.Errorf("unknown missing snapshot policy %q", plan.Policy)
+	}
+
+	s := stubResponse{status: http.StatusOK}
+	if plan.Status != 0 {
+		if plan.Status < 100 || plan.Status > 999 {
+			return "", stubResponse{}, fmt.Errorf("invalid stub status %d", plan.Status)
+		}
+		s.status = plan.Status
+	}
+	if plan.Body != "" {
+		s.body = []byte(plan.Body)
+	}
+	return missingStub, s, nil
+}
+
+func (s stubResponse) write(w http.ResponseWriter) {
+	if json.Valid(s.body) {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(s.status)
+	w.Write(s.body)
+}
+
+func (m MissingSnapshot) write(w http.ResponseWriter) {
+	fmt.Printf("MISSING: rc=%s ec=%s method=%s uri=%s sequence=%d policy=%s\n",
+		m.RequestContext, m.ExecutionContext, m.Method, m.Uri, m.ScopedSequence, m.Policy)
+	if data, err := json.Marshal(m); err == nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write(data)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// liveHeader keeps the headers of the call made by the service, the call isn't part of the replay
+// so the live dependency doesn't get the request context and isn't captured
+func liveHeader(header http.Header) http.Header {
+	retval := header.Clone()
+	for _, name := range runtimeHeaders {
+		retval.Del(name)
+	}
+	retval.Set(captureHeader, captureDisabled)
+	return retval
+}
+
+// relayResponse writes the response of a forwarded call
+func relayResponse(w http.ResponseWriter, resp *http.Response) error {
+	defer resp.Body.Close()
+
+	for name, val := range resp.Header {
+		if len(val) > 0 {
+			w.Header().Add(name, val[0])
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, err := io.Copy(w, resp.Body)
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyMissingSnapshot(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+	})
+
+	// Fails by default, naming the missing call
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", "serviceb=localhost:3001", "0"))
+	missing := MissingSnapshot{}
+	json.Unmarshal(w.Body.Bytes(), &missing)
+	if w.Code != http.StatusBadGateway || missing.Uri != "http://b/count" || missing.ExecutionContext != "e1" || missing.Policy != missingFail {
+		t.Errorf("Want %v Actual %v %v\n", http.StatusBadGateway, w.Code, missing)
+	}
+
+	w = httptest.NewRecorder()
+	config := inlinePlan(`{"version": 1, "missing": {"policy": "stub", "status": 404, "body": "{\"n\": 0}"}}`)
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", config, "0"))
+	if w.Code != http.StatusNotFound || w.Body.String() != `{"n": 0}` || w.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusNotFound, w.Code, w.Body.String())
+	}
+
+	var received http.Header
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("live"))
+	}))
+	defer live.Close()
+
+	w = httptest.NewRecorder()
+	r := proxyRequest("rc", "e1", live.URL+"/count", "", inlinePlan(`{"version": 1, "missing": {"policy": "live"}}`), "0")
+	r.Header.Set("X-Custom", "kept")
+	proxyHandler(w, r)
+	if w.Code != http.StatusAccepted || w.Body.String() != "live" {
+		t.Errorf("Want %v Actual %v %s\n", http.StatusAccepted, w.Code, w.Body.String())
+	}
+	if received.Get("X-Custom") != "kept" || received.Get(RequestContextHeader) != "" || received.Get(captureHeader) != captureDisabled {
+		t.Errorf("Want %v Actual %v\n", "runtime headers removed", received)
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", inlinePlan(`{"version": 1, "missing": {"policy": "retry"}}`), "0"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Want %v Actual %v\n", http.StatusBadRequest, w.Code)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// The overrides of a replay plan replace the observed values of service:observation, one value when a sequence
+// is set and every one otherwise. The value is JSON, the SDK decodes it into the type of the observer.
+type observationOverride struct {
+	name      string // Observation name as configured, used for values that weren't recorded
+	all       []byte // Every sequence, nil when not set
+	sequences map[int][]byte
+}
+
+// overrideConfig holds the overrides by lower case service:observation
+type overrideConfig map[string]*observationOverride
+
+func newOverrideConfig(plans []OverridePlan) (overrideConfig, error) {
+	config := make(overrideConfig)
+
+	for _, p := range plans {
+		service, name, ok := strings.Cut(p.Target, ":")
+		if !ok || service == "" || name == "" {
+			return nil, fmt.Errorf("invalid override target %q, expected <service>:<observation>", p.Target)
+		}
+		if !json.Valid(p.Value) {
+			return nil, fmt.Errorf("invalid value of override %s, expected JSON", p.Target)
+		}
+
+		o, ok := config[strings.ToLower(p.Target)]
+		if !ok {
+			o = &observationOverride{name: name, sequences: make(map[int][]byte)}
+			config[strings.ToLower(p.Target)] = o
+		}
+		if p.Sequence == nil {
+			o.all = p.Value
+			continue
+		}
+		if *p.Sequence < 0 {
+			return nil, fmt.Errorf("invalid sequence %d of override %s", *p.Sequence, p.Target)
+		}
+		o.sequences[*p.Sequence] = p.Value
+	}
+	return config, nil
+}
+
+// value returns the substitute of the observed value at seq, a single sequence goes before every sequence
+func (o *observationOverride) value(seq int) (ObservationData, bool) {
+	if data, ok := o.sequences[seq]; ok {
+		return ObservationData{Body: data, Override: true}, true
+	}
+	if o.all != nil {
+		return ObservationData{Body: o.all, Override: true}, true
+	}
+	return ObservationData{}, false
+}
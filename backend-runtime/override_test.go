@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestObservationOverride(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceC", ObservationName: "HitCounter", ScopedSequence: 0, Body: []byte{1}},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceC", ObservationName: "HitCounter", ScopedSequence: 1, Body: []byte{2}},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceC", ObservationName: "Now", Body: []byte{3}},
+	})
+
+	load := func(config string) (int, Observations) {
+		r := httptest.NewRequest("GET", "/runtime/observations", nil)
+		r.Header.Set(RequestContextHeader, "rc")
+		r.Header.Set(DebugConfigHeader, config)
+		w := httptest.NewRecorder()
+		observationHandler(w, r)
+
+		var obs Observations
+		json.Unmarshal(w.Body.Bytes(), &obs)
+		return w.Code, obs
+	}
+
+	_, obs := load(inlinePlan(`{"version": 1, "overrides": [{"target": "serviceC:HitCounter", "value": 0}, {"target": "serviceC:HitCounter", "sequence": 3, "value": 5}]}`))
+	counter := obs.Data["HitCounter"]
+	if len(counter) != 3 || string(counter[0].Body) != "0" || string(counter[1].Body) != "0" || string(counter[3].Body) != "5" || !counter[3].Override {
+		t.Errorf("Want %v Actual %v\n", "overridden counter", counter)
+	}
+	if now := obs.Data["Now"][0]; now.Override || string(now.Body) != "\x03" {
+		t.Errorf("Want %v Actual %v\n", "recorded value", now)
+	}
+
+	// An override goes before pass through, the other values are observed live
+	_, obs = load(inlinePlan(`{"version": 1, "pass": ["servicec:hitcounter"], "overrides": [{"target": "serviceC:HitCounter", "sequence": 1, "value": 5}]}`))
+	counter = obs.Data["HitCounter"]
+	if len(counter) != 1 || string(counter[1].Body) != "5" {
+		t.Errorf("Want %v Actual %v\n", "only sequence 1", counter)
+	}
+
+	for _, invalid := range []string{
+		inlinePlan(`{"version": 1, "overrides": [{"target": "servicec", "value": 0}]}`),
+		inlinePlan(`{"version": 1, "overrides": [{"target": "servicec:now", "sequence": -1, "value": 0}]}`),
+		inlinePlan(`{"version": 1, "overrides": [{"target": "servicec:now"}]}`),
+	} {
+		if code, _ := load(invalid); code != http.StatusBadRequest {
+			t.Errorf("Want %v Actual %v %v\n", http.StatusBadRequest, code, invalid)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Besides the name=host|name=host string, a debug config can be a versioned replay plan, sent inline as
+// v1:<plan JSON base64 url encoded without padding>, or stored with POST /runtime/plans and sent as plan:<id>.
+// Neither holds a =, which tells them apart from the pipe delimited config.
+const (
+	inlinePlanPrefix = "v1:"
+	storedPlanPrefix = "plan:"
+	planVersion      = 1
+
+	maxPlans = 1000
+)
+
+// ReplayPlan describes how a capture is replayed, every field but the version is optional
+type ReplayPlan struct {
+	Version    int               `json:"version"`
+	Mapping    map[string]string `json:"mapping,omitempty"`    // Hosts of the services to unfreeze by service name
+	Pass       []string          `json:"pass,omitempty"`       // Observations observed live, service:observation
+	Divergence string            `json:"divergence,omitempty"` // fail to refuse diverging calls
+	Missing    *MissingPlan      `json:"missing,omitempty"`
+	Latency    *LatencyPlan      `json:"latency,omitempty"`
+	Faults     []FaultPlan       `json:"faults,omitempty"`
+	Overrides  []OverridePlan    `json:"overrides,omitempty"`
+}
+
+type MissingPlan struct {
+	Policy string `json:"policy"`           // fail, live or stub
+	Status int    `json:"status,omitempty"` // Status of the stub, 200 when not set
+	Body   string `json:"body,omitempty"`   // Body of the stub
+}
+
+type LatencyPlan struct {
+	Factor   float64            `json:"factor,omitempty"`   // Every dependency
+	Services map[string]float64 `json:"services,omitempty"` // Dependencies by service name or host
+}
+
+type FaultPlan struct {
+	Target   string                     `json:"target"`             // Service or host of a dependency, service:observation for observations
+	Sequence *int                       `json:"sequence,omitempty"` // Scoped sequence of the only call or value affected
+	Status   int                        `json:"status,omitempty"`
+	Delay    string                     `json:"delay,omitempty"`
+	Error    string                     `json:"error,omitempty"` // Transport error kind, or message of an observation error
+	Set      map[string]json.RawMessage `json:"set,omitempty"`   // New JSON values by path
+}
+
+type OverridePlan struct {
+	Target   string          `json:"target"`             // service:observation
+	Sequence *int            `json:"sequence,omitempty"` // Every value of the observation when not set
+	Value    json.RawMessage `json:"value"`
+}
+
+// replayConfig is what a replay is configured from, built from its plan
+type replayConfig struct {
+	hosts      map[string]string // Hosts of the unfrozen services by lower case service name
+	pass       map[string]bool   // Observations observed live by lower case service:observation
+	divergence string
+	missing    string
+	stub       stubResponse
+	latency    latencyConfig
+	faults     faultConfig
+	overrides  overrideConfig
+}
+
+func (p ReplayPlan) config() (replayConfig, error) {
+	if p.Version != planVersion {
+		return replayConfig{}, fmt.Errorf("unsupported replay plan version %d", p.Version)
+	}
+
+	config := replayConfig{
+		hosts:      make(map[string]string, len(p.Mapping)),
+		pass:       make(map[string]bool, len(p.Pass)),
+		divergence: p.Divergence,
+	}
+	for name, host := range p.Mapping {
+		config.hosts[strings.ToLower(name)] = host
+	}
+	for _, observation := range p.Pass {
+		config.pass[strings.ToLower(observation)] = true
+	}
+
+	var err error
+	if config.missing, config.stub, err = missingPolicy(p.Missing); err != nil {
+		return replayConfig{}, err
+	}
+	if config.latency, err = newLatencyConfig(p.Latency); err != nil {
+		return replayConfig{}, err
+	}
+	if config.faults, err = newFaultConfig(p.Faults); err != nil {
+		return replayConfig{}, err
+	}
+	if config.overrides, err = newOverrideConfig(p.Overrides); err != nil {
+		return replayConfig{}, err
+	}
+	return config, nil
+}
+
+// parsePlan converts the name=host|service:observation=pass config of older clis into a replay plan
+func parsePlan(config string) ReplayPlan {
+	plan := ReplayPlan{Version: planVersion, Mapping: make(map[string]string)}
+	for key, value := range parseDebugConfig(config) {
+		if strings.Contains(key, ":") && value == "pass" {
+			plan.Pass = append(plan.Pass, key)
+		} else {
+			plan.Mapping[key] = value
+		}
+	}
+	return plan
+}
+
+// loadPlan reads the replay plan of a debug config, whichever way it was sent
+func loadPlan(config string) (ReplayPlan, error) {
+	if strings.Contains(config, "=") {
+		return parsePlan(config), nil
+	}
+
+	if encoded, ok := strings.CutPrefix(config, inlinePlanPrefix); ok {
+		data, err := base64.RawURLEncoding.DecodeString(encoded)
+		if err != nil {
+			return ReplayPlan{}, fmt.Errorf("invalid replay plan: %w", err)
+		}
+		var plan ReplayPlan
+		if err := json.Unmarshal(data, &plan); err != nil {
+			return ReplayPlan{}, fmt.Errorf("invalid replay plan: %w", err)
+		}
+		return plan, nil
+	}
+
+	if id, ok := strings.CutPrefix(config, storedPlanPrefix); ok {
+		plan, err := plans.Get(id)
+		if err != nil {
+			return ReplayPlan{}, fmt.Errorf("%s: %w", id, err)
+		}
+		return plan, nil
+	}
+	return parsePlan(config), nil
+}
+
+// loadReplayConfig configures the replay from the plan of the debug config
+func loadReplayConfig(config string) (replayConfig, error) {
+	plan, err := loadPlan(config)
+	if err != nil {
+		return replayConfig{}, err
+	}
+	return plan.config()
+}
+
+// planStore keeps the last maxPlans plans, and the ids of as many plans it dropped to tell an evicted
+// plan apart from an unknown one. When a path is set the plans are saved there so they survive a restart.
+type planStore struct {
+	path    string
+	mu      sync.Mutex
+	plans   map[string]ReplayPlan
+	order   []string
+	evicted []string
+}
+
+type planFile struct {
+	Plans   map[string]ReplayPlan `json:"plans"`
+	Order   []string              `json:"order"`
+	Evicted []string              `json:"evicted,omitempty"`
+}
+
+var (
+	errPlanNotFound = errors.New("replay plan not found")
+	errPlanEvicted  = fmt.Errorf("replay plan evicted, the runtime keeps the last %d plans", maxPlans)
+)
+
+var plans = &planStore{plans: make(map[string]ReplayPlan)}
+
+func NewPlanStore(path string) (*planStore, error) {
+	s := &planStore{
+		path:  path,
+		plans: make(map[string]ReplayPlan),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	file := planFile{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, id := range file.Order {
+		if plan, ok := file.Plans[id]; ok {
+			s.plans[id] = plan
+			s.order = append(s.order, id)
+		}
+	}
+	s.evicted = file.Evicted
+	return s, nil
+}
+
+// Add keeps the plan and returns its id, the oldest plan is evicted once maxPlans are kept
+func (s *planStore) Add(plan ReplayPlan) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.order) >= maxPlans {
+		delete(s.plans, s.order[0])
+		s.evicted = append(s.evicted, s.order[0])
+		s.order = s.order[1:]
+		if len(s.evicted) > maxPlans {
+			s.evicted = s.evicted[1:]
+		}
+	}
+	s.plans[id] = plan
+	s.order = append(s.order, id)
+	return id, s.save()
+}
+
+// Get returns errPlanEvicted for a plan dropped to make room for newer ones
+func (s *planStore) Get(id string) (ReplayPlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if plan, ok := s.plans[id]; ok {
+		return plan, nil
+	}
+	if slices.Contains(s.evicted, id) {
+		return ReplayPlan{}, errPlanEvicted
+	}
+	return ReplayPlan{}, errPlanNotFound
+}
+
+// save writes the plans to disk, callers must hold the lock
+func (s *planStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(planFile{Plans: s.plans, Order: s.order, Evicted: s.evicted})
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// planHandler stores a replay plan with POST and returns it with GET /runtime/plans?id=
+func planHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		badRequest bool
+		oErr       error
+	)
+
+	defer func() {
+		if badRequest {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if oErr != nil {
+			fmt.Printf("ERROR: %s\n", oErr.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	var body []byte
+	switch r.Method {
+	case "GET":
+		plan, err := plans.Get(r.URL.Query().Get("id"))
+		if errors.Is(err, errPlanEvicted) {
+			w.WriteHeader(http.StatusGone)
+			return
+		} else if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if body, oErr = json.Marshal(plan); oErr != nil {
+			return
+		}
+	case "POST":
+		var plan ReplayPlan
+		if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+			badRequest = true
+			return
+		}
+		if _, err := plan.config(); err != nil {
+			badRequest = true
+			fmt.Printf("ERROR: %s\n", err.Error())
+			return
+		}
+
+		id, err := plans.Add(plan)
+		if err != nil {
+			oErr = err
+			return
+		}
+		if body, oErr = json.Marshal(map[string]string{"id": id}); oErr != nil {
+			return
+		}
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, oErr = w.Write(body)
+		return
+	default:
+		badRequest = true
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_, oErr = w.Write(body)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func inlinePlan(plan string) string {
+	return inlinePlanPrefix + base64.RawURLEncoding.EncodeToString([]byte(plan))
+}
+
+func TestLoadReplayConfig(t *testing.T) {
+	config, err := loadReplayConfig(inlinePlan(`{
+		"version": 1,
+		"mapping": {"ServiceA": "proxy.local/?a=1|b", "Missing": "localhost:3002"},
+		"pass": ["ServiceC:HitCounter"],
+		"missing": {"policy": "stub", "status": 404, "body": "gone"},
+		"latency": {"factor": 1.5, "services": {"ServiceB": 0}},
+		"faults": [{"target": "ServiceB", "sequence": 1, "status": 503, "set": {"count": 0}}],
+		"overrides": [{"target": "ServiceC:HitCounter", "sequence": 2, "value": 5}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if act := config.hosts["servicea"]; act != "proxy.local/?a=1|b" || config.hosts["missing"] != "localhost:3002" {
+		t.Errorf("Want %v Actual %v\n", "proxy.local/?a=1|b", config.hosts)
+	}
+	if !config.pass["servicec:hitcounter"] {
+		t.Errorf("Want %v Actual %v\n", "pass", config.pass)
+	}
+	if config.missing != missingStub || config.stub.status != 404 || string(config.stub.body) != "gone" {
+		t.Errorf("Want %v Actual %v %v\n", "stub 404", config.missing, config.stub)
+	}
+	if config.latency.delay("ServiceB", "http://b/", 100) != 0 || config.latency.delay("", "http://c/", 100) == 0 {
+		t.Errorf("Want %v Actual %v\n", "latency", config.latency)
+	}
+
+	if f := config.faults.dependency("ServiceB", "http://b/", 1); f == nil || f.status != 503 || f.set["count"] != float64(0) {
+		t.Errorf("Want %v Actual %v\n", "fault of sequence 1", f)
+	}
+	if f := config.faults.dependency("ServiceB", "http://b/", 0); f != nil {
+		t.Errorf("Want %v Actual %v\n", nil, f)
+	}
+	if data, ok := config.overrides["servicec:hitcounter"].value(2); !ok || string(data.Body) != "5" {
+		t.Errorf("Want %v Actual %v\n", "5", data)
+	}
+
+	// The config of older clis only maps services and passes observations, everything else is a service
+	plan, err := loadPlan("servicea=localhost:3000|servicec:hitcounter=pass|missing=localhost:3001")
+	want := ReplayPlan{
+		Version: planVersion,
+		Mapping: map[string]string{"servicea": "localhost:3000", "missing": "localhost:3001"},
+		Pass:    []string{"servicec:hitcounter"},
+	}
+	if err != nil || !reflect.DeepEqual(want, plan) {
+		t.Errorf("Want %+v Actual %+v %v\n", want, plan, err)
+	}
+
+	for _, invalid := range []string{inlinePlan(`{"version": 2}`), inlinePlan(`{`), inlinePlanPrefix + "!!", storedPlanPrefix + "unknown",
+		inlinePlan(`{"version": 1, "latency": {"factor": -1}}`), inlinePlan(`{"version": 1, "overrides": [{"target": "a", "value": 1}]}`)} {
+		if _, err := loadReplayConfig(invalid); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+}
+
+func TestStoredPlan(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", StatusCode: 200},
+	})
+
+	w := httptest.NewRecorder()
+	planHandler(w, httptest.NewRequest("POST", "/runtime/plans", strings.NewReader(`{"version": 1, "faults": [{"target": "b", "status": 503}]}`)))
+	var created map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil || w.Code != http.StatusCreated || created["id"] == "" {
+		t.Fatalf("Want %v Actual %v %v\n", http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", storedPlanPrefix+created["id"], "0"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Want %v Actual %v\n", http.StatusServiceUnavailable, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	planHandler(w, httptest.NewRequest("GET", "/runtime/plans?id="+created["id"], nil))
+	var plan ReplayPlan
+	if err := json.Unmarshal(w.Body.Bytes(), &plan); err != nil || len(plan.Faults) != 1 || plan.Faults[0].Status != 503 {
+		t.Errorf("Want %v Actual %v %v\n", "stored plan", plan, err)
+	}
+
+	for _, invalid := range []string{`{"version": 1, "faults": [{"target": "b", "error": "boom"}]}`, `{"version": 0}`, `{`} {
+		w = httptest.NewRecorder()
+		planHandler(w, httptest.NewRequest("POST", "/runtime/plans", strings.NewReader(invalid)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Want %v Actual %v %v\n", http.StatusBadRequest, w.Code, invalid)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	planHandler(w, httptest.NewRequest("GET", "/runtime/plans?id=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Want %v Actual %v\n", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestPlanStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plans.json")
+	s, err := NewPlanStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := s.Add(ReplayPlan{Version: planVersion, Divergence: divergenceFail})
+
+	// Plans survive a restart
+	if s, err = NewPlanStore(path); err != nil {
+		t.Fatal(err)
+	}
+	if plan, err := s.Get(first); err != nil || plan.Divergence != divergenceFail {
+		t.Errorf("Want %v Actual %v %v\n", divergenceFail, plan, err)
+	}
+
+	// The oldest plan is evicted, which isn't reported as an unknown plan
+	s = &planStore{plans: make(map[string]ReplayPlan)}
+	first, _ = s.Add(ReplayPlan{Version: planVersion})
+	for range maxPlans {
+		s.Add(ReplayPlan{Version: planVersion})
+	}
+	if _, err := s.Get(first); err != errPlanEvicted {
+		t.Errorf("Want %v Actual %v\n", errPlanEvicted, err)
+	}
+	if _, err := s.Get("unknown"); err != errPlanNotFound {
+		t.Errorf("Want %v Actual %v\n", errPlanNotFound, err)
+	}
+
+	defer func(saved *planStore) { plans = saved }(plans)
+	plans = s
+	w := httptest.NewRecorder()
+	planHandler(w, httptest.NewRequest("GET", "/runtime/plans?id="+first, nil))
+	if w.Code != http.StatusGone {
+		t.Errorf("Want %v Actual %v\n", http.StatusGone, w.Code)
+	}
+}
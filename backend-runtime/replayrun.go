@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ReplayRun asks the runtime to replay a capture, like the replay command of the cli.
+// Mapping holds the services to unfreeze and the host they run on.
+type ReplayRun struct {
+	RequestContext string            `json:"rc"`
+	Mapping        map[string]string `json:"mapping"`
+}
+
+type ReplayResult struct {
+	Service    string              `json:"service"`
+	Url        string              `json:"url"`
+	StatusCode int                 `json:"status"`
+	Header     map[string][]string `json:"header"`
+	Body       []byte              `json:"body"`
+	Error      string              `json:"error,omitempty"`
+}
+
+var replayClient = &http.Client{Timeout: 5 * time.Minute}
+
+func replayRunHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		badRequest bool
+		oErr       error
+	)
+
+	defer func() {
+		if badRequest {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if oErr != nil {
+			fmt.Printf("ERROR: %s\n", oErr.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	if r.Method != "POST" {
+		badRequest = true
+		return
+	}
+
+	run := ReplayRun{}
+	if err := json.NewDecoder(r.Body).Decode(&run); err != nil || run.RequestContext == "" || len(run.Mapping) == 0 {
+		badRequest = true
+		return
+	}
+
+	mapping := make(map[string]string, len(run.Mapping))
+	for name, host := range run.Mapping {
+		mapping[strings.ToLower(name)] = host
+	}
+
+	records, err := store.Get(run.RequestContext)
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
+		}
+		return
+	}
+
+	config, err := debugConfig(mapping)
+	if err != nil {
+		oErr = err
+		return
+	}
+
+	tree := buildRequestTree(records, edgeExecutionContext(records, run.RequestContext))
+	results := replayTree(tree, mapping, config)
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		oErr = err
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, oErr = w.Write(body)
+}
+
+// edgeExecutionContext finds the execution context of the initial request
+func edgeExecutionContext(records []Record, rc string) string {
+	for _, r := range records {
+		// Records of inital request has cause context as request context
+		if r.RequestContext == rc && r.CauseContext == rc {
+			return r.ExecutionContext
+		}
+	}
+	return ""
+}
+
+// replayTree sends the recorded request of every mapped service, dependencies of a
+// replayed service are replayed by the service itself through the proxy
+func replayTree(request Request, mapping map[string]string, config string) []ReplayResult {
+	host, ok := mapping[strings.ToLower(request.In.ServiceName)]
+	if !ok {
+		results := make([]ReplayResult, 0)
+		for _, dep := range request.Dependencies {
+			if dep.Reference.In.ServiceName != "" {
+				results = append(results, replayTree(dep.Reference, mapping, config)...)
+			}
+		}
+		return results
+	}
+
+	in := request.In
+	result := ReplayResult{Service: in.ServiceName, Url: "http://" + host + in.Uri}
+
+	req, err := http.NewRequest(in.Method, result.Url, bytes.NewReader(in.Body))
+	if err != nil {
+		result.Error = err.Error()
+		return []ReplayResult{result}
+	}
+
+	for name, vals := range in.Header {
+		for _, v := range vals {
+			req.Header.Add(name, v)
+		}
+	}
+	req.Header.Set(RequestContextHeader, in.RequestContext)
+	req.Header.Set(CauseContextHeader, in.CauseContext)
+	req.Header.Set(ExecutionContextHeader, in.ExecutionContext)
+	req.Header.Set(ServiceDebugHeader, DebugEnabled)
+	req.Header.Set(DebugConfigHeader, config)
+
+	resp, err := replayClient.Do(req)
+	if err != nil {
+		result.Error = err.Error()
+		return []ReplayResult{result}
+	}
+	defer resp.Body.Close()
+
+	result.StatusCode = resp.StatusCode
+	result.Header = resp.Header
+	if result.Body, err = io.ReadAll(resp.Body); err != nil {
+		result.Error = err.Error()
+	}
+	return []ReplayResult{result}
+}
+
+// debugConfig stores the replay plan unfreezing the mapped services, the services replaying with an
+// ingest token only call out to the mapped hosts with a stored plan
+func debugConfig(mapping map[string]string) (string, error) {
+	id, err := plans.Add(ReplayPlan{Version: planVersion, Mapping: mapping})
+	if err != nil {
+		return "", err
+	}
+	return storedPlanPrefix + id, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReplayTree(t *testing.T) {
+	var debug, config string
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debug, config = r.Header.Get(ServiceDebugHeader), r.Header.Get(DebugConfigHeader)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("replayed"))
+	}))
+	defer service.Close()
+	host := strings.TrimPrefix(service.URL, "http://")
+
+	tree := Request{
+		In: Record{ServiceName: "ServiceA", Method: "GET", Uri: "/a"},
+		Dependencies: []Dependency{
+			{Reference: Request{In: Record{ServiceName: "ServiceB", Method: "POST", Uri: "/b", Body: []byte("in")}}},
+		},
+	}
+
+	defer func(saved *planStore) { plans = saved }(plans)
+	plans = &planStore{plans: make(map[string]ReplayPlan)}
+	stored, err := debugConfig(map[string]string{"serviceb": host})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := replayTree(tree, map[string]string{"serviceb": host}, stored)
+	if len(results) != 1 {
+		t.Fatalf("Want %v Actual %v\n", 1, len(results))
+	}
+	if results[0].Service != "ServiceB" || results[0].StatusCode != http.StatusTeapot || string(results[0].Body) != "replayed" {
+		t.Errorf("Want %v Actual %v\n", "ServiceB", results[0])
+	}
+	if replay, err := loadReplayConfig(config); err != nil || debug != DebugEnabled || replay.hosts["serviceb"] != host {
+		t.Errorf("Want %v Actual %v %v\n", host, debug, config)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// pinSet holds the request contexts that are being debugged and must never be evicted.
+// When a path is set the pins are saved there so they survive a restart.
+type pinSet struct {
+	path string
+	mu   sync.RWMutex
+	pins map[string]time.Time
+}
+
+func NewPinSet(path string) (*pinSet, error) {
+	p := &pinSet{
+		path: path,
+		pins: make(map[string]time.Time),
+	}
+
+	if path == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &p.pins); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return p, nil
+}
+
+func (p *pinSet) Pin(rc string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.pins[rc]; ok {
+		return nil
+	}
+	p.pins[rc] = time.Now()
+	return p.save()
+}
+
+func (p *pinSet) Unpin(rc string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.pins[rc]; !ok {
+		return nil
+	}
+	delete(p.pins, rc)
+	return p.save()
+}
+
+func (p *pinSet) Pinned(rc string) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, ok := p.pins[rc]
+	return ok
+}
+
+func (p *pinSet) List() map[string]time.Time {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	pins := make(map[string]time.Time, len(p.pins))
+	for rc, t := range p.pins {
+		pins[rc] = t
+	}
+	return pins
+}
+
+// save writes the pins to disk, callers must hold the write lock
+func (p *pinSet) save() error {
+	if p.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(p.pins)
+	if err != nil {
+		return err
+	}
+
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// Retention limits what the store keeps, a zero value disables the limit
+type Retention struct {
+	MaxAge      time.Duration
+	MaxBytes    int64
+	MaxContexts int
+}
+
+func (r Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxBytes > 0 || r.MaxContexts > 0
+}
+
+// Evict removes the oldest unpinned request contexts until the store is within the retention limits.
+// It returns the number of request contexts removed.
+func (r Retention) Evict(s Store, pins *pinSet, now time.Time) (int, error) {
+	infos := s.Contexts()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Time.Before(infos[j].Time) })
+
+	var totalBytes int64
+	for i := range infos {
+		totalBytes += infos[i].Bytes
+	}
+	totalContexts := len(infos)
+
+	evicted := 0
+	for i := range infos {
+		expired := r.MaxAge > 0 && now.Sub(infos[i].Time) > r.MaxAge
+		overBytes := r.MaxBytes > 0 && totalBytes > r.MaxBytes
+		overContexts := r.MaxContexts > 0 && totalContexts > r.MaxContexts
+
+		if !expired && !overBytes && !overContexts {
+			// Everything after this one is newer, so it can't be expired either
+			break
+		}
+		if pins.Pinned(infos[i].RequestContext) {
+			continue
+		}
+
+		if err := s.Delete(infos[i].RequestContext); err != nil {
+			return evicted, err
+		}
+		totalBytes -= infos[i].Bytes
+		totalContexts--
+		evicted++
+	}
+
+	return evicted, nil
+}
+
+func evictInBackground(s Store, pins *pinSet, retention Retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		evicted, err := retention.Evict(s, pins, now)
+		if err != nil {
+			fmt.Printf("ERROR: eviction failed: %s\n", err.Error())
+		}
+		if evicted > 0 {
+			fmt.Printf("Evicted %d request contexts\n", evicted)
+		}
+	}
+}
+
+func pinHandler(pins *pinSet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var (
+			badRequest bool
+			oErr       error
+		)
+
+		defer func() {
+			if badRequest {
+				w.WriteHeader(http.StatusBadRequest)
+			} else if oErr != nil {
+				fmt.Printf("ERROR: %s\n", oErr.Error())
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+		}()
+
+		if r.Method == "GET" {
+			body, err := json.Marshal(pins.List())
+			if err != nil {
+				oErr = err
+				return
+			}
+			w.Header().Add("Content-Type", "application/json")
+			_, oErr = w.Write(body)
+			return
+		}
+
+		rc := r.URL.Query().Get("rc")
+		if rc == "" {
+			badRequest = true
+			return
+		}
+
+		switch r.Method {
+		case "POST":
+			oErr = pins.Pin(rc)
+		case "DELETE":
+			oErr = pins.Unpin(rc)
+		default:
+			badRequest = true
+			return
+		}
+
+		if oErr == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
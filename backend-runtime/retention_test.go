@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetentionEvict(t *testing.T) {
+	now := time.Now()
+	s := NewMemoryStore()
+
+	for i, rc := range []string{"a", "b", "c", "d"} {
+		records := testRecords(rc, 1)
+		records[0].Time = now.Add(time.Duration(i-4) * time.Hour)
+		s.Append(records)
+	}
+
+	pins, _ := NewPinSet("")
+	pins.Pin("a")
+
+	retention := Retention{MaxAge: 150 * time.Minute, MaxContexts: 2}
+	evicted, err := retention.Evict(s, pins, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b is expired, c is over the limit and a is pinned
+	if evicted != 2 {
+		t.Errorf("Want %v Actual %v\n", 2, evicted)
+	}
+	for rc, want := range map[string]bool{"a": true, "b": false, "c": false, "d": true} {
+		if _, err := s.Get(rc); (err == nil) != want {
+			t.Errorf("%s Want %v Actual %v\n", rc, want, err)
+		}
+	}
+}
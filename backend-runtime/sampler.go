@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SamplingRule keeps a request tree when all of its non empty fields match the edge request
+type SamplingRule struct {
+	Service   string `json:"service"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`       // Prefix of the edge request path
+	MinStatus int    `json:"min_status"` // Lowest edge response status code
+	Header    string `json:"header"`     // Header of the edge request, either Name or Name=Value
+}
+
+func (r SamplingRule) Match(in, out *Record) bool {
+	if r.Service != "" && !strings.EqualFold(r.Service, in.ServiceName) {
+		return false
+	}
+	if r.Method != "" && !strings.EqualFold(r.Method, in.Method) {
+		return false
+	}
+	if r.Path != "" {
+		path := in.Uri
+		if u, err := url.Parse(in.Uri); err == nil {
+			path = u.Path
+		}
+		if !strings.HasPrefix(path, r.Path) {
+			return false
+		}
+	}
+	if r.MinStatus > 0 && out.StatusCode < r.MinStatus {
+		return false
+	}
+	if r.Header != "" {
+		name, value, hasValue := strings.Cut(r.Header, "=")
+		vals, ok := headerValues(in.Header, name)
+		if !ok {
+			return false
+		}
+		if hasValue && !contains(vals, value) {
+			return false
+		}
+	}
+	return true
+}
+
+func headerValues(header map[string][]string, name string) ([]string, bool) {
+	for k, v := range header {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func contains(vals []string, val string) bool {
+	for _, v := range vals {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func LoadSamplingRules(path string) ([]SamplingRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]SamplingRule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// TailSampling decides which request trees are worth keeping once they are complete
+type TailSampling struct {
+	Grace            time.Duration // Time without new records after which a request tree is considered complete
+	LatencyThreshold time.Duration // Edge responses slower than this are kept, 0 disables
+	Rules            []SamplingRule
+}
+
+// Keep decides on a complete request tree. A tree without an edge response is kept,
+// the edge service most likely crashed before it could respond. Recorded replays are
+// always kept, they were asked for.
+func (t TailSampling) Keep(records []Record) bool {
+	if len(records) > 0 && replayOf(records[0].RequestContext) != "" {
+		return true
+	}
+
+	var in, out *Record
+	for i := range records {
+		if records[i].RequestContext != records[i].CauseContext {
+			continue
+		}
+		switch records[i].RecordType {
+		case RequestRecordType:
+			in = &records[i]
+		case ResponseRecordType:
+			out = &records[i]
+		}
+	}
+
+	if out == nil {
+		return true
+	}
+	if in == nil {
+		in = out
+	}
+
+	if out.StatusCode >= 500 {
+		return true
+	}
+	if t.LatencyThreshold > 0 && time.Duration(out.Duration)*time.Millisecond >= t.LatencyThreshold {
+		return true
+	}
+	for _, rule := range t.Rules {
+		if rule.Match(in, out) {
+			return true
+		}
+	}
+	return false
+}
+
+type pendingTree struct {
+	records  []Record
+	lastSeen time.Time
+}
+
+type decision struct {
+	keep bool
+	at   time.Time
+}
+
+// tailSampler buffers request trees and only appends the ones TailSampling keeps to the underlying store.
+// Decisions are remembered for a while so late records follow the rest of their tree.
+type tailSampler struct {
+	Store
+	policy    TailSampling
+	mu        sync.Mutex
+	pending   map[string]*pendingTree
+	decisions map[string]decision
+	stop      chan struct{}
+}
+
+func NewTailSampler(s Store, policy TailSampling) *tailSampler {
+	t := &tailSampler{
+		Store:     s,
+		policy:    policy,
+		pending:   make(map[string]*pendingTree),
+		decisions: make(map[string]decision),
+		stop:      make(chan struct{}),
+	}
+
+	interval := policy.Grace / 4
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	go t.decideInBackground(interval)
+
+	return t
+}
+
+func (t *tailSampler) Append(records []Record) error {
+	now := time.Now()
+	keep := make([]Record, 0)
+
+	t.mu.Lock()
+	for i := range records {
+		rc := records[i].RequestContext
+		if d, ok := t.decisions[rc]; ok {
+			if d.keep {
+				keep = append(keep, records[i])
+			}
+			continue
+		}
+
+		tree, ok := t.pending[rc]
+		if !ok {
+			tree = &pendingTree{}
+			t.pending[rc] = tree
+		}
+		tree.records = append(tree.records, records[i])
+		tree.lastSeen = now
+	}
+	t.mu.Unlock()
+
+	return t.Store.Append(keep)
+}
+
+// Decide settles every request tree that hasn't received a record within the grace window
+func (t *tailSampler) Decide(now time.Time) error {
+	keep := make([]Record, 0)
+	kept := make(map[string]*pendingTree)
+
+	t.mu.Lock()
+	for rc, tree := range t.pending {
+		if now.Sub(tree.lastSeen) < t.policy.Grace {
+			continue
+		}
+		d := decision{keep: t.policy.Keep(tree.records), at: now}
+		if d.keep {
+			keep = append(keep, tree.records...)
+			kept[rc] = tree
+		}
+		t.decisions[rc] = d
+		delete(t.pending, rc)
+	}
+
+	for rc, d := range t.decisions {
+		if now.Sub(d.at) > 10*t.policy.Grace {
+			delete(t.decisions, rc)
+		}
+	}
+	t.mu.Unlock()
+
+	if err := t.Store.Append(keep); err != nil {
+		// The kept trees wait for the next round, late records of them are pending again until then
+		t.mu.Lock()
+		for rc, tree := range kept {
+			delete(t.decisions, rc)
+			if late, ok := t.pending[rc]; ok {
+				tree.records = append(tree.records, late.records...)
+			}
+			t.pending[rc] = tree
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (t *tailSampler) decideInBackground(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			if err := t.Decide(now); err != nil {
+				fmt.Printf("ERROR: sampling decision failed: %s\n", err.Error())
+			}
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// Close keeps every request tree still waiting for a decision, so nothing is lost when the runtime is stopped
+func (t *tailSampler) Close() error {
+	close(t.stop)
+
+	t.mu.Lock()
+	keep := make([]Record, 0)
+	for rc, tree := range t.pending {
+		keep = append(keep, tree.records...)
+		delete(t.pending, rc)
+	}
+	t.mu.Unlock()
+
+	if err := t.Store.Append(keep); err != nil {
+		t.Store.Close()
+		return err
+	}
+	return t.Store.Close()
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func edgeTree(rc string, status int, duration int64) []Record {
+	return []Record{
+		{RequestContext: rc, CauseContext: rc, ExecutionContext: "ec", RecordType: RequestRecordType, ServiceName: "ServiceA", Method: "GET", Uri: "/boost?name=x"},
+		{RequestContext: rc, CauseContext: "ec", ExecutionContext: "dc", RecordType: RequestRecordType, ServiceName: "ServiceB"},
+		{RequestContext: rc, CauseContext: rc, ExecutionContext: "ec", RecordType: ResponseRecordType, ServiceName: "ServiceA", StatusCode: status, Duration: duration},
+	}
+}
+
+func TestTailSampler(t *testing.T) {
+	policy := TailSampling{
+		Grace:            time.Hour,
+		LatencyThreshold: time.Second,
+		Rules:            []SamplingRule{{Service: "servicea", Path: "/debug"}},
+	}
+	s := NewMemoryStore()
+	sampler := NewTailSampler(s, policy)
+	defer sampler.Close()
+
+	sampler.Append(edgeTree("ok", 200, 10))
+	sampler.Append(edgeTree("failed", 500, 10))
+	sampler.Append(edgeTree("slow", 200, 2000))
+	sampler.Append(edgeTree("crashed", 200, 10)[:2])
+	sampler.Append(edgeTree("ok"+replayContextSeparator+"1", 200, 10))
+
+	if _, err := s.Get("failed"); err == nil {
+		t.Errorf("Request tree stored before the grace window")
+	}
+
+	if err := sampler.Decide(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	for rc, want := range map[string]bool{"ok": false, "failed": true, "slow": true, "crashed": true, "ok" + replayContextSeparator + "1": true} {
+		if _, err := s.Get(rc); (err == nil) != want {
+			t.Errorf("%s Want %v Actual %v\n", rc, want, err)
+		}
+	}
+
+	// Late records follow the decision of their tree
+	sampler.Append(edgeTree("failed", 500, 10)[1:2])
+	sampler.Append(edgeTree("ok", 200, 10)[1:2])
+
+	if records, _ := s.Get("failed"); len(records) != 4 {
+		t.Errorf("Want %v Actual %v\n", 4, len(records))
+	}
+	if _, err := s.Get("ok"); err == nil {
+		t.Errorf("Late record of a discarded tree stored")
+	}
+}
+
+// failingStore refuses appends while failing is set
+type failingStore struct {
+	Store
+	failing bool
+}
+
+func (s *failingStore) Append(records []Record) error {
+	if s.failing {
+		return errors.New("disk full")
+	}
+	return s.Store.Append(records)
+}
+
+func TestTailSamplerAppendFailure(t *testing.T) {
+	s := &failingStore{Store: NewMemoryStore(), failing: true}
+	sampler := NewTailSampler(s, TailSampling{Grace: time.Hour})
+	defer sampler.Close()
+
+	sampler.Append(edgeTree("failed", 500, 10))
+	if err := sampler.Decide(time.Now().Add(2 * time.Hour)); err == nil {
+		t.Errorf("Want %v Actual %v\n", "error", err)
+	}
+
+	// The tree and its late records are kept on the next decision
+	sampler.Append(edgeTree("failed", 500, 10)[1:2])
+	s.failing = false
+	if err := sampler.Decide(time.Now().Add(2 * time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if records, _ := s.Get("failed"); len(records) != 4 {
+		t.Errorf("Want %v Actual %v\n", 4, len(records))
+	}
+}
+
+func TestSamplingRuleMatch(t *testing.T) {
+	tree := edgeTree("rc", 200, 10)
+	tree[0].Header = map[string][]string{"X-Tenant": {"acme"}}
+
+	rules := map[SamplingRule]bool{
+		{Path: "/boost"}:                      true,
+		{Path: "/other"}:                      false,
+		{Method: "post"}:                      false,
+		{Header: "X-Tenant"}:                  true,
+		{Header: "x-tenant=acme"}:             true,
+		{Header: "X-Tenant=other"}:            false,
+		{Service: "ServiceA", MinStatus: 400}: false,
+	}
+
+	for rule, want := range rules {
+		if act := rule.Match(&tree[0], &tree[2]); act != want {
+			t.Errorf("%+v Want %v Actual %v\n", rule, want, act)
+		}
+	}
+}
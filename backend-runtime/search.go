@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 1000
+)
+
+// SearchQuery filters captured request trees, empty fields match everything.
+// Everything but Text is answered from the summaries of the store, Text loads the records.
+type SearchQuery struct {
+	Service    string        // Service that took part in the request tree
+	Route      string        // Prefix of the edge request path
+	Method     string        // Method of the edge request
+	MinStatus  int           // Edge response status range, inclusive
+	MaxStatus  int           //
+	From       time.Time     // Capture time range, inclusive
+	To         time.Time     //
+	MinLatency time.Duration // Edge response latency range, inclusive
+	MaxLatency time.Duration //
+	Text       string        // Substring of a header value, body or observation error of any record
+	ReplayOf   string        // Only the recorded replays of this request context
+	Limit      int           // Most recent matches returned
+}
+
+// parseSearchQuery reads the query from URL parameters. Status is either a code like 503 or a class like 5xx,
+// from and to are RFC 3339 times and latencies are durations like 250ms.
+func parseSearchQuery(values url.Values) (SearchQuery, error) {
+	q := SearchQuery{
+		Service:  values.Get("service"),
+		Route:    values.Get("route"),
+		Method:   values.Get("method"),
+		Text:     values.Get("text"),
+		ReplayOf: values.Get("replay_of"),
+		Limit:    defaultSearchLimit,
+	}
+
+	if status := values.Get("status"); status != "" {
+		if class, ok := strings.CutSuffix(strings.ToLower(status), "xx"); ok && len(class) == 1 {
+			n, err := strconv.Atoi(class)
+			if err != nil {
+				return q, fmt.Errorf("invalid status %q", status)
+			}
+			q.MinStatus, q.MaxStatus = n*100, n*100+99
+		} else {
+			n, err := strconv.Atoi(status)
+			if err != nil {
+				return q, fmt.Errorf("invalid status %q", status)
+			}
+			q.MinStatus, q.MaxStatus = n, n
+		}
+	}
+
+	for name, t := range map[string]*time.Time{"from": &q.From, "to": &q.To} {
+		if v := values.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*t = parsed
+		}
+	}
+
+	for name, d := range map[string]*time.Duration{"min_latency": &q.MinLatency, "max_latency": &q.MaxLatency} {
+		if v := values.Get(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*d = parsed
+		}
+	}
+
+	if v := values.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return q, fmt.Errorf("invalid limit %q", v)
+		}
+		q.Limit = min(n, maxSearchLimit)
+	}
+	return q, nil
+}
+
+func (q SearchQuery) matchInfo(info *ContextInfo) bool {
+	if q.Service != "" && !slices.ContainsFunc(info.Services, func(s string) bool { return strings.EqualFold(s, q.Service) }) {
+		return false
+	}
+	if q.ReplayOf != "" && q.ReplayOf != info.ReplayOf {
+		return false
+	}
+	if q.Method != "" && !strings.EqualFold(q.Method, info.Method) {
+		return false
+	}
+	if q.Route != "" {
+		path := info.Uri
+		if u, err := url.Parse(info.Uri); err == nil {
+			path = u.Path
+		}
+		if !strings.HasPrefix(path, q.Route) {
+			return false
+		}
+	}
+	if q.MinStatus > 0 && (info.StatusCode < q.MinStatus || info.StatusCode > q.MaxStatus) {
+		return false
+	}
+	if !q.From.IsZero() && info.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && info.Time.After(q.To) {
+		return false
+	}
+	latency := time.Duration(info.Duration) * time.Millisecond
+	if q.MinLatency > 0 && latency < q.MinLatency {
+		return false
+	}
+	if q.MaxLatency > 0 && latency > q.MaxLatency {
+		return false
+	}
+	return true
+}
+
+func (q SearchQuery) matchText(records []Record) bool {
+	text := []byte(q.Text)
+	for i := range records {
+		if bytes.Contains(records[i].Body, text) || bytes.Contains(records[i].ObservationError, text) ||
+			strings.Contains(records[i].TransportError, q.Text) {
+			return true
+		}
+		for _, vals := range records[i].Header {
+			for _, v := range vals {
+				if strings.Contains(v, q.Text) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Search returns the summaries of the most recent request trees matching the query, newest first
+func Search(s Store, q SearchQuery) ([]ContextInfo, error) {
+	infos := s.Contexts()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Time.After(infos[j].Time) })
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+
+	retval := make([]ContextInfo, 0, min(limit, len(infos)))
+	for i := range infos {
+		if len(retval) == limit {
+			break
+		}
+		if !q.matchInfo(&infos[i]) {
+			continue
+		}
+
+		if q.Text != "" {
+			records, err := s.Get(infos[i].RequestContext)
+			if errors.Is(err, ErrNotFound) {
+				// Evicted since the summaries were taken
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !q.matchText(records) {
+				continue
+			}
+		}
+		retval = append(retval, infos[i])
+	}
+	return retval, nil
+}
+
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		badRequest bool
+		oErr       error
+	)
+
+	defer func() {
+		if badRequest {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if oErr != nil {
+			fmt.Printf("ERROR: %s\n", oErr.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	if r.Method != "GET" {
+		badRequest = true
+		return
+	}
+
+	query, err := parseSearchQuery(r.URL.Query())
+	if err != nil {
+		fmt.Printf("ERROR: %s\n", err.Error())
+		badRequest = true
+		return
+	}
+
+	results, err := Search(store, query)
+	if err != nil {
+		if status, ok := storeErrorStatus(err); ok {
+			w.WriteHeader(status)
+		} else {
+			oErr = err
+		}
+		return
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		oErr = err
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, oErr = w.Write(body)
+}
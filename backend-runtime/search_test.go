@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func searchRecords(rc, service, uri string, status int, duration int64, tm time.Time, body string) []Record {
+	return []Record{
+		{RequestContext: rc, CauseContext: rc, ExecutionContext: rc, RecordType: RequestRecordType, ServiceName: service, Method: "GET", Uri: uri, Time: tm},
+		{RequestContext: rc, CauseContext: rc, ExecutionContext: "dep", RecordType: ObservedRecordType, ServiceName: "ServiceC", Body: []byte(body), Time: tm},
+		{RequestContext: rc, CauseContext: rc, ExecutionContext: rc, RecordType: ResponseRecordType, ServiceName: service, StatusCode: status, Duration: duration, Time: tm},
+	}
+}
+
+func TestSearch(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	s.Append(searchRecords("a", "ServiceA", "/boost?n=1", 200, 10, now.Add(-2*time.Hour), `{"count":1}`))
+	s.Append(searchRecords("b", "ServiceA", "/boost", 503, 2500, now.Add(-time.Hour), `{"count":2}`))
+	s.Append(searchRecords("c", "ServiceB", "/count", 404, 20, now, `{"count":3}`))
+	s.Append(searchRecords("a.replay.s1", "ServiceD", "/replay", 200, 30, now.Add(-3*time.Hour), `{}`))
+
+	cases := []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"c", "b", "a", "a.replay.s1"}},
+		{"limit=1", []string{"c"}},
+		{"service=servicea", []string{"b", "a"}},
+		{"service=ServiceC", []string{"c", "b", "a", "a.replay.s1"}},
+		{"route=/boost&method=get", []string{"b", "a"}},
+		{"status=5xx", []string{"b"}},
+		{"status=404", []string{"c"}},
+		{"min_latency=1s", []string{"b"}},
+		{"max_latency=15ms", []string{"a"}},
+		{"from=" + url.QueryEscape(now.Add(-90*time.Minute).Format(time.RFC3339)), []string{"c", "b"}},
+		{"to=" + url.QueryEscape(now.Add(-90*time.Minute).Format(time.RFC3339)), []string{"a", "a.replay.s1"}},
+		{"replay_of=a", []string{"a.replay.s1"}},
+		{"text=%22count%22:2", []string{"b"}},
+	}
+
+	for _, c := range cases {
+		values, _ := url.ParseQuery(c.query)
+		q, err := parseSearchQuery(values)
+		if err != nil {
+			t.Fatalf("%s: %s", c.query, err.Error())
+		}
+		results, err := Search(s, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		act := make([]string, len(results))
+		for i := range results {
+			act[i] = results[i].RequestContext
+		}
+		if len(act) != len(c.want) {
+			t.Errorf("%s Want %v Actual %v\n", c.query, c.want, act)
+			continue
+		}
+		for i := range act {
+			if act[i] != c.want[i] {
+				t.Errorf("%s Want %v Actual %v\n", c.query, c.want, act)
+				break
+			}
+		}
+	}
+
+	if _, err := parseSearchQuery(url.Values{"status": {"5x"}}); err == nil {
+		t.Errorf("Invalid status accepted")
+	}
+}
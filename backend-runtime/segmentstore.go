@@ -0,0 +1,816 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"hash/maphash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// On disk layout of a segment
+//
+//	header: magic[4] | version u8
+//	entry:  length u32 | crc32 u32 | kind u8 | payload
+//
+// length covers kind and payload, the checksum is calculated over the same bytes.
+// Compaction replaces a segment with a copy of its live entries under the same id.
+const (
+	segmentExt         = ".seg"
+	segmentMagic       = "RSEG"
+	segmentVersion     = 1
+	compactionTempFile = "compact.tmp"
+
+	entryHeaderSize = 8
+
+	entryRecord    byte = 1
+	entryTombstone byte = 2
+
+	// segmentShards is the number of independently locked partitions of the index
+	segmentShards = 64
+
+	// compactRatio is the share of a sealed segment that has to be garbage before it's compacted
+	compactRatio = 0.5
+)
+
+type recordRef struct {
+	segment uint64
+	offset  int64
+	size    uint32
+}
+
+type indexEntry struct {
+	refs []recordRef
+	keys recordIndex // Positions in refs
+	info ContextInfo
+}
+
+func (e *indexEntry) add(ref recordRef, rec *Record) {
+	e.keys.add(rec, len(e.refs))
+	e.refs = append(e.refs, ref)
+	e.info.add(rec, int64(entryHeaderSize+ref.size))
+}
+
+type segment struct {
+	id   uint64
+	file *os.File
+	size int64
+	live atomic.Int64 // Bytes of the entries still referenced, everything else is garbage
+}
+
+func (seg *segment) garbageRatio() float64 {
+	data := seg.size - int64(len(segmentHeader()))
+	if data <= 0 {
+		return 0
+	}
+	return float64(data-seg.live.Load()) / float64(data)
+}
+
+type segmentShard struct {
+	mu    sync.RWMutex
+	index map[string]*indexEntry
+}
+
+// Writes to the active segment are serialized by writeMu and synced without holding any
+// other lock. The index is sharded by request context like the memory store, so a replay
+// is only held up by the index update of an append to a request context of its shard.
+// segMu guards the segment list, it's always taken after a shard lock.
+// compactMu serializes compactions and deletes, it's taken before any other lock.
+type segmentStore struct {
+	dir       string
+	maxSize   int64
+	compactMu sync.Mutex
+	writeMu   sync.Mutex
+	segMu     sync.RWMutex
+	segments  map[uint64]*segment
+	active    *segment
+	seed      maphash.Seed
+	shards    []segmentShard
+	dead      map[string][]uint64 // Segments still holding records of a deleted request context, guarded by compactMu
+}
+
+func OpenSegmentStore(dir string, maxSize int64) (*segmentStore, error) {
+	return openSegmentStore(dir, maxSize, segmentShards)
+}
+
+func openSegmentStore(dir string, maxSize int64, shards int) (*segmentStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	// A left over from an interrupted compaction, the original segments are still intact
+	_ = os.Remove(filepath.Join(dir, compactionTempFile))
+
+	s := &segmentStore{
+		dir:      dir,
+		maxSize:  maxSize,
+		segments: make(map[uint64]*segment),
+		seed:     maphash.MakeSeed(),
+		shards:   make([]segmentShard, shards),
+		dead:     make(map[string][]uint64),
+	}
+	for i := range s.shards {
+		s.shards[i].index = make(map[string]*indexEntry)
+	}
+
+	ids, err := s.listSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		seg, err := s.loadSegment(id)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.active = seg
+	}
+
+	if s.active == nil || s.active.size >= s.maxSize {
+		if err := s.rotate(); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (s *segmentStore) segmentPath(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%016d%s", id, segmentExt))
+}
+
+func (s *segmentStore) listSegments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint64, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func segmentHeader() []byte {
+	return append([]byte(segmentMagic), segmentVersion)
+}
+
+// parseSegmentHeader returns the size of the header
+func parseSegmentHeader(r io.Reader) (int64, error) {
+	head := make([]byte, len(segmentMagic)+1)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return 0, fmt.Errorf("invalid segment header: %w", err)
+	}
+	if string(head[:len(segmentMagic)]) != segmentMagic || head[len(segmentMagic)] != segmentVersion {
+		return 0, fmt.Errorf("invalid segment header")
+	}
+	return int64(len(head)), nil
+}
+
+// loadSegment opens a segment and adds all of its entries to the index.
+// A torn or corrupt tail, left by a crash in the middle of a write, is truncated.
+func (s *segmentStore) loadSegment(id uint64) (*segment, error) {
+	path := s.segmentPath(id)
+	f, err := os.OpenFile(path, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(f)
+	offset, err := parseSegmentHeader(reader)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	// The segment is listed before its entries are read, tombstones take their records
+	// off the live bytes of this and earlier segments
+	seg := &segment{id: id, file: f}
+	s.segments[id] = seg
+
+	for {
+		kind, payload, err := readEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			fmt.Printf("WARNING: truncating %s at %d: %s\n", path, offset, err.Error())
+			if err := f.Truncate(offset); err != nil {
+				return nil, err
+			}
+			break
+		}
+
+		size := uint32(len(payload) + 1)
+		switch kind {
+		case entryRecord:
+			rec := Record{}
+			if err := json.Unmarshal(payload, &rec); err != nil {
+				return nil, fmt.Errorf("%s: corrupt record at %d: %w", path, offset, err)
+			}
+			s.entry(rec.RequestContext).add(recordRef{segment: id, offset: offset, size: size}, &rec)
+			seg.live.Add(entryHeaderSize + int64(size))
+		case entryTombstone:
+			// A tombstone is only needed while the records it deletes are around
+			if s.drop(string(payload)) {
+				seg.live.Add(entryHeaderSize + int64(size))
+			}
+		}
+		offset += entryHeaderSize + int64(size)
+	}
+
+	seg.size = offset
+	return seg, nil
+}
+
+func (s *segmentStore) shard(rc string) *segmentShard {
+	return &s.shards[maphash.String(s.seed, rc)%uint64(len(s.shards))]
+}
+
+// entry and drop change the index, callers must hold the write lock of the shard of rc.
+// drop also changes the dead records, callers must hold compactMu unless the store is being opened.
+func (s *segmentStore) entry(rc string) *indexEntry {
+	shard := s.shard(rc)
+	e, ok := shard.index[rc]
+	if !ok {
+		e = &indexEntry{keys: make(recordIndex), info: ContextInfo{RequestContext: rc}}
+		shard.index[rc] = e
+	}
+	return e
+}
+
+func (s *segmentStore) drop(rc string) bool {
+	shard := s.shard(rc)
+	e, ok := shard.index[rc]
+	if !ok {
+		return false
+	}
+	delete(shard.index, rc)
+
+	s.segMu.RLock()
+	defer s.segMu.RUnlock()
+	for _, ref := range e.refs {
+		if seg, ok := s.segments[ref.segment]; ok {
+			seg.live.Add(-(entryHeaderSize + int64(ref.size)))
+		}
+		if ids := s.dead[rc]; len(ids) == 0 || ids[len(ids)-1] != ref.segment {
+			s.dead[rc] = append(ids, ref.segment)
+		}
+	}
+	return true
+}
+
+// lockAll takes the write lock of every shard and the segment list, for changes to the whole index
+func (s *segmentStore) lockAll() {
+	for i := range s.shards {
+		s.shards[i].mu.Lock()
+	}
+	s.segMu.Lock()
+}
+
+func (s *segmentStore) unlockAll() {
+	s.segMu.Unlock()
+	for i := range s.shards {
+		s.shards[i].mu.Unlock()
+	}
+}
+
+func readEntry(r io.Reader) (byte, []byte, error) {
+	head := make([]byte, entryHeaderSize)
+	if n, err := io.ReadFull(r, head); err != nil {
+		if n == 0 && err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		return 0, nil, fmt.Errorf("partial entry header")
+	}
+
+	size := binary.BigEndian.Uint32(head)
+	if size == 0 {
+		return 0, nil, fmt.Errorf("empty entry")
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, fmt.Errorf("partial entry")
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(head[4:]) {
+		return 0, nil, fmt.Errorf("checksum mismatch")
+	}
+	return body[0], body[1:], nil
+}
+
+func appendEntry(buf []byte, kind byte, payload []byte) []byte {
+	body := make([]byte, 0, len(payload)+1)
+	body = append(body, kind)
+	body = append(body, payload...)
+
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(body)))
+	buf = binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(body))
+	return append(buf, body...)
+}
+
+// rotate seals the active segment and starts a new one, callers must hold writeMu and the write lock of segMu
+func (s *segmentStore) rotate() error {
+	var id uint64 = 1
+	if s.active != nil {
+		id = s.active.id + 1
+	}
+
+	// The header is written to a temporary file first so a crash never leaves a segment without one
+	header := segmentHeader()
+	path := s.segmentPath(id)
+	f, err := os.OpenFile(path+".tmp", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(header); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		f.Close()
+		return err
+	}
+	syncDir(s.dir)
+
+	seg := &segment{id: id, file: f, size: int64(len(header))}
+	s.segments[id] = seg
+	s.active = seg
+	return nil
+}
+
+func (s *segmentStore) Append(records []Record) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	buf := make([]byte, 0, 512*len(records))
+	sizes := make([]uint32, len(records))
+	for i := range records {
+		payload, err := json.Marshal(records[i])
+		if err != nil {
+			return err
+		}
+		start := len(buf)
+		buf = appendEntry(buf, entryRecord, payload)
+		sizes[i] = uint32(len(buf) - start - entryHeaderSize)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	seg, err := s.writeActive(buf)
+	if err != nil {
+		return err
+	}
+
+	// Batches usually hold records of a single request context, so the shard lock is
+	// only taken again when the request context changes
+	var (
+		shard  *segmentShard
+		locked string
+	)
+	offset := seg.size
+	for i := range records {
+		rc := records[i].RequestContext
+		if shard == nil || rc != locked {
+			if next := s.shard(rc); next != shard {
+				if shard != nil {
+					shard.mu.Unlock()
+				}
+				shard = next
+				shard.mu.Lock()
+			}
+			locked = rc
+		}
+		s.entry(rc).add(recordRef{segment: seg.id, offset: offset, size: sizes[i]}, &records[i])
+		offset += entryHeaderSize + int64(sizes[i])
+	}
+	shard.mu.Unlock()
+	seg.size = offset
+	seg.live.Add(int64(len(buf)))
+
+	return nil
+}
+
+// writeActive appends entries to the active segment, rotating it first when it's full.
+// Callers must hold writeMu, the size of the segment is updated by the caller.
+func (s *segmentStore) writeActive(buf []byte) (*segment, error) {
+	if s.active.size >= s.maxSize {
+		s.segMu.Lock()
+		err := s.rotate()
+		s.segMu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seg := s.active
+	if _, err := seg.file.WriteAt(buf, seg.size); err != nil {
+		_ = seg.file.Truncate(seg.size)
+		return nil, err
+	}
+	if err := seg.file.Sync(); err != nil {
+		_ = seg.file.Truncate(seg.size)
+		return nil, err
+	}
+	return seg, nil
+}
+
+// readRecord reads a record of the index, callers must hold the read lock of its shard
+func (s *segmentStore) readRecord(ref recordRef) (Record, error) {
+	s.segMu.RLock()
+	seg, ok := s.segments[ref.segment]
+	s.segMu.RUnlock()
+	if !ok {
+		return Record{}, fmt.Errorf("segment %d not found", ref.segment)
+	}
+
+	body := make([]byte, ref.size)
+	if _, err := seg.file.ReadAt(body, ref.offset+entryHeaderSize); err != nil {
+		return Record{}, err
+	}
+
+	rec := Record{}
+	if body[0] != entryRecord {
+		return rec, fmt.Errorf("segment %d offset %d is not a record", ref.segment, ref.offset)
+	}
+	err := json.Unmarshal(body[1:], &rec)
+	return rec, err
+}
+
+func (s *segmentStore) Get(rc string) ([]Record, error) {
+	shard := s.shard(rc)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	e, ok := shard.index[rc]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	records := make([]Record, 0, len(e.refs))
+	for _, ref := range e.refs {
+		rec, err := s.readRecord(ref)
+		if err != nil {
+			return nil, fmt.Errorf("reading record of %s: %w", rc, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func (s *segmentStore) Find(rc string, key RecordKey) ([]Record, error) {
+	shard := s.shard(rc)
+	shard.mu.RLock()
+	defer shard.mu.RUnlock()
+
+	e, ok := shard.index[rc]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	positions := e.keys[key]
+	records := make([]Record, 0, len(positions))
+	for _, pos := range positions {
+		rec, err := s.readRecord(e.refs[pos])
+		if err != nil {
+			return nil, fmt.Errorf("reading record of %s: %w", rc, err)
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// Compact reclaims the space of deleted request contexts. Every sealed segment with at least
+// compactRatio garbage is replaced by a copy of its live entries, segments are copied without
+// holding any index lock so appends and replays carry on until the index is switched over.
+func (s *segmentStore) Compact() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	var segments []*segment
+	for _, seg := range s.sealed() {
+		if seg.garbageRatio() >= compactRatio {
+			segments = append(segments, seg)
+		}
+	}
+	return s.compact(segments, nil)
+}
+
+// Rewrite passes every record through transform and compacts all segments on the way.
+// Appends wait until it's done, so every record in the store is transformed.
+func (s *segmentStore) Rewrite(transform func(Record) (Record, error)) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if s.active.size > int64(len(segmentHeader())) {
+		s.segMu.Lock()
+		err := s.rotate()
+		s.segMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return s.compact(s.sealed(), transform)
+}
+
+// sealed lists all segments but the active one, oldest first
+func (s *segmentStore) sealed() []*segment {
+	s.segMu.RLock()
+	defer s.segMu.RUnlock()
+
+	sealed := make([]*segment, 0, len(s.segments))
+	for id, seg := range s.segments {
+		if id != s.active.id {
+			sealed = append(sealed, seg)
+		}
+	}
+	sort.Slice(sealed, func(i, j int) bool { return sealed[i].id < sealed[j].id })
+	return sealed
+}
+
+// liveRef is a record of a segment still in the index, at position pos of its request context
+type liveRef struct {
+	rc  string
+	pos int
+}
+
+// compact replaces the segments with copies of their live entries, callers must hold compactMu.
+// Segments are compacted oldest first, a tombstone is dropped once no older segment holds
+// records it deletes.
+func (s *segmentStore) compact(segments []*segment, transform func(Record) (Record, error)) error {
+	if len(segments) == 0 {
+		return nil
+	}
+
+	// Sealed segments are never written to and deletes wait for compactMu, so the live
+	// records don't change until the index is switched over
+	live := make(map[uint64]map[int64]liveRef, len(segments))
+	for _, seg := range segments {
+		live[seg.id] = make(map[int64]liveRef)
+	}
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for rc, e := range shard.index {
+			for pos, ref := range e.refs {
+				if refs, ok := live[ref.segment]; ok {
+					refs[ref.offset] = liveRef{rc: rc, pos: pos}
+				}
+			}
+		}
+		shard.mu.RUnlock()
+	}
+
+	for _, seg := range segments {
+		if err := s.compactSegment(seg, live[seg.id], transform); err != nil {
+			return fmt.Errorf("compacting segment %d: %w", seg.id, err)
+		}
+	}
+	return nil
+}
+
+func (s *segmentStore) compactSegment(seg *segment, live map[int64]liveRef, transform func(Record) (Record, error)) error {
+	tmpPath := filepath.Join(s.dir, compactionTempFile)
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(seg.file, 0, seg.size))
+	oldOffset, err := parseSegmentHeader(reader)
+	if err != nil {
+		return fail(err)
+	}
+
+	writer := bufio.NewWriter(tmp)
+	header := segmentHeader()
+	if _, err := writer.Write(header); err != nil {
+		return fail(err)
+	}
+
+	moved := make(map[liveRef]recordRef, len(live))
+	offset := int64(len(header))
+	for {
+		kind, payload, err := readEntry(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+		current := oldOffset
+		oldOffset += entryHeaderSize + int64(len(payload)+1)
+
+		switch kind {
+		case entryRecord:
+			at, ok := live[current]
+			if !ok {
+				continue
+			}
+			if transform != nil {
+				rec := Record{}
+				if err := json.Unmarshal(payload, &rec); err != nil {
+					return fail(err)
+				}
+				if rec, err = transform(rec); err != nil {
+					return fail(err)
+				}
+				if payload, err = json.Marshal(rec); err != nil {
+					return fail(err)
+				}
+			}
+			entry := appendEntry(nil, entryRecord, payload)
+			if _, err := writer.Write(entry); err != nil {
+				return fail(err)
+			}
+			moved[at] = recordRef{segment: seg.id, offset: offset, size: uint32(len(entry) - entryHeaderSize)}
+			offset += int64(len(entry))
+		case entryTombstone:
+			if !s.deletesBefore(string(payload), seg.id) {
+				continue
+			}
+			entry := appendEntry(nil, entryTombstone, payload)
+			if _, err := writer.Write(entry); err != nil {
+				return fail(err)
+			}
+			offset += int64(len(entry))
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		return fail(err)
+	}
+
+	// Nothing is left of a segment without live entries
+	if offset == int64(len(header)) {
+		tmp.Close()
+		os.Remove(tmpPath)
+		s.swap(seg, nil, moved)
+		if err := os.Remove(s.segmentPath(seg.id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		syncDir(s.dir)
+		return nil
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tmpPath, s.segmentPath(seg.id)); err != nil {
+		return fail(err)
+	}
+	syncDir(s.dir)
+
+	compacted := &segment{id: seg.id, file: tmp, size: offset}
+	compacted.live.Store(offset - int64(len(header)))
+	s.swap(seg, compacted, moved)
+	return nil
+}
+
+// deletesBefore tells if a tombstone of rc in segment id still deletes records of an older segment
+func (s *segmentStore) deletesBefore(rc string, id uint64) bool {
+	for _, dead := range s.dead[rc] {
+		if dead < id {
+			return true
+		}
+	}
+	return false
+}
+
+// swap points the index at the compacted copy of a segment, or drops the segment when
+// nothing was left of it. The index is only locked while the references are switched.
+func (s *segmentStore) swap(seg, compacted *segment, moved map[liveRef]recordRef) {
+	s.lockAll()
+	for at, ref := range moved {
+		e := s.shard(at.rc).index[at.rc]
+		old := e.refs[at.pos]
+		e.refs[at.pos] = ref
+		e.info.Bytes += int64(ref.size) - int64(old.size)
+	}
+	if compacted != nil {
+		s.segments[seg.id] = compacted
+	} else {
+		delete(s.segments, seg.id)
+	}
+	s.unlockAll()
+
+	// Replays started before the swap are done with the old file, their shard locks were released
+	seg.file.Close()
+
+	for rc, ids := range s.dead {
+		kept := ids[:0]
+		for _, id := range ids {
+			if id != seg.id {
+				kept = append(kept, id)
+			}
+		}
+		if len(kept) == 0 {
+			delete(s.dead, rc)
+		} else {
+			s.dead[rc] = kept
+		}
+	}
+}
+
+// Delete removes a request context, a tombstone is logged so it stays deleted after a restart.
+// The space is reclaimed by the next compaction.
+func (s *segmentStore) Delete(rc string) error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	shard := s.shard(rc)
+	shard.mu.RLock()
+	_, ok := shard.index[rc]
+	shard.mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	buf := appendEntry(nil, entryTombstone, []byte(rc))
+	seg, err := s.writeActive(buf)
+	if err != nil {
+		return err
+	}
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	seg.size += int64(len(buf))
+	seg.live.Add(int64(len(buf)))
+	s.drop(rc)
+	return nil
+}
+
+func (s *segmentStore) Contexts() []ContextInfo {
+	infos := make([]ContextInfo, 0)
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.mu.RLock()
+		for _, e := range shard.index {
+			infos = append(infos, e.info)
+		}
+		shard.mu.RUnlock()
+	}
+	return infos
+}
+
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
+
+func (s *segmentStore) Close() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.segMu.Lock()
+	defer s.segMu.Unlock()
+
+	var retErr error
+	for id, seg := range s.segments {
+		if err := seg.file.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+		delete(s.segments, id)
+	}
+	s.active = nil
+	return retErr
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func testRecords(rc string, n int) []Record {
+	records := make([]Record, n)
+	for i := range records {
+		records[i] = Record{
+			RequestContext:     rc,
+			CauseContext:       rc,
+			ExecutionContext:   "ec",
+			RecordType:         DependencyResponseRecordType,
+			DepencencySequence: i,
+			Body:               []byte("body"),
+		}
+	}
+	return records
+}
+
+func checkSequence(t *testing.T, s Store, rc string, want int) {
+	t.Helper()
+
+	records, err := s.Get(rc)
+	if err != nil {
+		t.Fatalf("Request context %s: %s", rc, err.Error())
+	}
+	if len(records) != want {
+		t.Fatalf("Want %v Actual %v\n", want, len(records))
+	}
+	for i, r := range records {
+		if r.DepencencySequence != i {
+			t.Errorf("Want %v Actual %v\n", i, r.DepencencySequence)
+		}
+	}
+}
+
+func TestSegmentStoreReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testRecords("a", 5)); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkSequence(t, s, "a", 5)
+}
+
+func TestSegmentStoreTornWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(testRecords("a", 3)); err != nil {
+		t.Fatal(err)
+	}
+	path := s.segmentPath(s.active.id)
+	s.Close()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, 1, 2})
+	f.Close()
+
+	s, err = OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkSequence(t, s, "a", 3)
+
+	if err := s.Append(testRecords("b", 1)); err != nil {
+		t.Fatal(err)
+	}
+	checkSequence(t, s, "b", 1)
+}
+
+func TestSegmentStoreCompaction(t *testing.T) {
+	dir := t.TempDir()
+
+	// Tiny segments so every append starts a new one
+	s, err := OpenSegmentStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := testRecords("a", 6)
+	for i := range records {
+		if err := s.Append(records[i : i+1]); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Append(testRecords("b", 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing to reclaim yet
+	segments := len(s.segments)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.segments) != segments {
+		t.Errorf("Want %v Actual %v\n", segments, len(s.segments))
+	}
+
+	// The segments of b are all garbage and get removed, the segments of a are left alone
+	if err := s.Delete("b"); err != nil {
+		t.Fatal(err)
+	}
+	segments = len(s.segments)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.segments) != segments-6 {
+		t.Errorf("Want %v Actual %v\n", segments-6, len(s.segments))
+	}
+	if err := s.Append(testRecords("c", 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSequence(t, s, "a", 6)
+	s.Close()
+
+	s, err = OpenSegmentStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	checkSequence(t, s, "a", 6)
+	checkSequence(t, s, "c", 1)
+	if _, err := s.Get("b"); err != ErrNotFound {
+		t.Errorf("Deleted request context b still found")
+	}
+}
+
+func TestSegmentStoreCompactionTombstones(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSegmentStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Segment 1 is mostly the live record w, the deleted x isn't worth compacting
+	live := testRecords("w", 1)
+	live[0].Body = make([]byte, 512)
+	s.Append(append(testRecords("x", 1), live...))
+	s.Delete("x")
+
+	// Segment 2 is mostly the deleted z, with the tombstones of x and z
+	dead := testRecords("z", 1)
+	dead[0].Body = make([]byte, 512)
+	s.Append(dead)
+	s.Delete("z")
+	s.Append(testRecords("y", 1))
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if r := s.segments[1].garbageRatio(); r == 0 || r >= compactRatio {
+		t.Errorf("Want %v Actual %v\n", "segment 1 left alone", r)
+	}
+	if r := s.segments[2].garbageRatio(); r != 0 {
+		t.Errorf("Want %v Actual %v\n", 0, r)
+	}
+	s.Close()
+
+	// The tombstone of x is kept as its record is still in segment 1
+	s, err = OpenSegmentStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, rc := range []string{"x", "z"} {
+		if _, err := s.Get(rc); err != ErrNotFound {
+			t.Errorf("Deleted request context %s still found", rc)
+		}
+	}
+	checkSequence(t, s, "w", 1)
+	checkSequence(t, s, "y", 1)
+}
+
+func TestSegmentStoreCompactionConcurrent(t *testing.T) {
+	s, err := OpenSegmentStore(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := range 20 {
+		s.Append(testRecords(fmt.Sprintf("old-%d", i), 5))
+	}
+	for i := 0; i < 20; i += 2 {
+		s.Delete(fmt.Sprintf("old-%d", i))
+	}
+
+	// Replays and appends carry on while segments are compacted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			if err := s.Append(testRecords(fmt.Sprintf("new-%d", i), 5)); err != nil {
+				t.Error(err)
+				return
+			}
+			checkSequence(t, s, fmt.Sprintf("old-%d", 2*(i%10)+1), 5)
+		}
+	}()
+	for range 5 {
+		if err := s.Compact(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+
+	for i := range 200 {
+		checkSequence(t, s, fmt.Sprintf("new-%d", i), 5)
+	}
+}
+
+func TestSegmentStoreDelete(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append(testRecords("a", 2))
+	s.Append(testRecords("b", 2))
+	if err := s.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s, err = OpenSegmentStore(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if _, err := s.Get("a"); err != ErrNotFound {
+		t.Errorf("Deleted request context a still found")
+	}
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	checkSequence(t, s, "b", 2)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ReplaySessionHeader carries the id of the replay session, created by whoever starts the replay
+	ReplaySessionHeader = "X-Replay-Session"
+
+	maxSessions      = 1000
+	maxSessionEvents = 10000
+
+	// A recorded replay is captured under the original request context joined with the replay session
+	replayContextSeparator = ".replay."
+)
+
+const (
+	SnapshotEvent     = "snapshot"     // A dependency call was served from the capture
+	ForwardEvent      = "forward"      // A dependency call was forwarded to a live service
+	RejectedEvent     = "rejected"     // A diverging dependency call was refused
+	ObservationsEvent = "observations" // Observations were loaded by a replayed service
+	StubEvent         = "stub"         // A dependency call without snapshot was served the configured stub
+	MissingEvent      = "missing"      // A dependency call without snapshot was refused
+)
+
+// SessionEvent is something the runtime did for a replay
+type SessionEvent struct {
+	Time             time.Time `json:"tm"`
+	Kind             string    `json:"kind"`
+	ExecutionContext string    `json:"ec"` // Execution that made the call or loaded the observations
+	Caller           string    `json:"caller,omitempty"`
+	ServiceName      string    `json:"sn,omitempty"` // Service that was called
+	Method           string    `json:"rm,omitempty"`
+	Uri              string    `json:"ru,omitempty"`
+	ScopedSequence   int       `json:"sq"`
+	StatusCode       int       `json:"st,omitempty"`
+	Duration         int64     `json:"dr"` // Milliseconds
+	Divergence       string    `json:"divergence,omitempty"`
+	Detail           string    `json:"detail,omitempty"`
+}
+
+// ReplaySession collects what happened during one replay, sessions are created by their first event
+type ReplaySession struct {
+	ID             string         `json:"id"`
+	RequestContext string         `json:"rc"`
+	Started        time.Time      `json:"started"`
+	Events         []SessionEvent `json:"events"`
+}
+
+type sessionLog struct {
+	mu       sync.Mutex
+	sessions map[string]*ReplaySession
+	order    []string
+}
+
+var sessions = &sessionLog{sessions: make(map[string]*ReplaySession)}
+
+func (l *sessionLog) Add(id, rc string, event SessionEvent) {
+	if id == "" {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.sessions[id]
+	if !ok {
+		if len(l.order) >= maxSessions {
+			delete(l.sessions, l.order[0])
+			l.order = l.order[1:]
+		}
+		s = &ReplaySession{ID: id, RequestContext: rc, Started: event.Time}
+		l.sessions[id] = s
+		l.order = append(l.order, id)
+	}
+	if len(s.Events) < maxSessionEvents {
+		s.Events = append(s.Events, event)
+	}
+}
+
+func (l *sessionLog) Get(id string) (ReplaySession, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s, ok := l.sessions[id]
+	if !ok {
+		return ReplaySession{}, false
+	}
+	copied := *s
+	copied.Events = append([]SessionEvent(nil), s.Events...)
+	return copied, true
+}
+
+// replayOf returns the request context a recorded replay was made from, empty for other captures
+func replayOf(rc string) string {
+	original, _, ok := strings.Cut(rc, replayContextSeparator)
+	if !ok {
+		return ""
+	}
+	return original
+}
+
+func sessionHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		badRequest bool
+		oErr       error
+	)
+
+	defer func() {
+		if badRequest {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if oErr != nil {
+			fmt.Printf("ERROR: %s\n", oErr.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}()
+
+	if r.Method != "GET" {
+		badRequest = true
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		badRequest = true
+		return
+	}
+
+	session, ok := sessions.Get(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	body, err := json.Marshal(session)
+	if err != nil {
+		oErr = err
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	_, oErr = w.Write(body)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReplaySession(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", DependencyContext: "e2", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", StatusCode: 201, Duration: 12},
+		{RequestContext: "rc", ExecutionContext: "e2", RecordType: RequestRecordType, ServiceName: "ServiceB", Method: "POST", Uri: "/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceA", ObservationName: "Now"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: ObservedRecordType, ServiceName: "ServiceA", ObservationName: "Rand"},
+	})
+
+	r := httptest.NewRequest("GET", "/runtime/observations", nil)
+	r.Header.Set(RequestContextHeader, "rc")
+	r.Header.Set(ExecutionContextHeader, "e1")
+	r.Header.Set(DebugConfigHeader, "servicea:rand=pass")
+	r.Header.Set(ReplaySessionHeader, "s1")
+	observationHandler(httptest.NewRecorder(), r)
+
+	r = proxyRequest("rc", "e1", "http://b/count", "", "", "0")
+	r.Header.Set(ReplaySessionHeader, "s1")
+	proxyHandler(httptest.NewRecorder(), r)
+
+	r = proxyRequest("rc", "e1", "http://b/other", "", inlinePlan(`{"version": 1, "divergence": "fail"}`), "0")
+	r.Header.Set(ReplaySessionHeader, "s1")
+	proxyHandler(httptest.NewRecorder(), r)
+
+	// Requests without a session aren't logged
+	proxyHandler(httptest.NewRecorder(), proxyRequest("rc", "e1", "http://b/count", "", "", "0"))
+
+	w := httptest.NewRecorder()
+	sessionHandler(w, httptest.NewRequest("GET", "/runtime/sessions?id=s1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Want %v Actual %v\n", http.StatusOK, w.Code)
+	}
+
+	session := ReplaySession{}
+	if err := json.Unmarshal(w.Body.Bytes(), &session); err != nil {
+		t.Fatal(err)
+	}
+	if session.RequestContext != "rc" || len(session.Events) != 3 {
+		t.Fatalf("Want %v Actual %v\n", 3, session)
+	}
+
+	want := []SessionEvent{
+		{Kind: ObservationsEvent, ExecutionContext: "e1", Detail: "1 values served, passed servicea:rand"},
+		{Kind: SnapshotEvent, ExecutionContext: "e1", Caller: "ServiceA", ServiceName: "ServiceB", Method: "POST", Uri: "http://b/count", StatusCode: 201, Duration: 12},
+		{Kind: RejectedEvent, ExecutionContext: "e1", Caller: "ServiceA", Method: "POST", Uri: "http://b/other", StatusCode: http.StatusConflict, Divergence: "uri"},
+	}
+	for i := range want {
+		act := session.Events[i]
+		act.Time = want[i].Time
+		if act != want[i] {
+			t.Errorf("Want %v Actual %v\n", want[i], act)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	sessionHandler(w, httptest.NewRequest("GET", "/runtime/sessions?id=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Want %v Actual %v\n", http.StatusNotFound, w.Code)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"hash/maphash"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound       = errors.New("request context not found")
+	ErrKeyUnavailable = errors.New("encryption key unavailable")
+)
+
+// storeErrorStatus maps the errors a store is expected to return to a response status
+func storeErrorStatus(err error) (int, bool) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound, true
+	case errors.Is(err, ErrKeyUnavailable):
+		return http.StatusServiceUnavailable, true
+	}
+	return 0, false
+}
+
+// Store keeps captured records grouped by request context.
+// Records of a request context are always returned in the order they were appended.
+type Store interface {
+	Append(records []Record) error
+	Get(rc string) ([]Record, error)
+	Find(rc string, key RecordKey) ([]Record, error)
+	Delete(rc string) error
+	Contexts() []ContextInfo
+	Close() error
+}
+
+// RecordKey is what records of a request context are indexed by at ingestion, so replays can look up
+// the records they serve without scanning the whole request tree. Dependency records are keyed by the
+// execution context that made the call, the URI and the scoped sequence, other records only by execution context.
+type RecordKey struct {
+	RecordType       RecordType
+	ExecutionContext string
+	Uri              string
+	ScopedSequence   int
+}
+
+func keyOf(rec *Record) RecordKey {
+	key := RecordKey{RecordType: rec.RecordType, ExecutionContext: rec.ExecutionContext}
+	if rec.RecordType == DependencyRequestRecordType || rec.RecordType == DependencyResponseRecordType {
+		key.Uri = rec.Uri
+		key.ScopedSequence = rec.ScopedSequence
+	}
+	return key
+}
+
+// recordIndex maps keys to the positions of their records within a request context
+type recordIndex map[RecordKey][]int
+
+func (idx recordIndex) add(rec *Record, pos int) {
+	key := keyOf(rec)
+	idx[key] = append(idx[key], pos)
+}
+
+// ContextInfo summarises a stored request context without loading its records
+type ContextInfo struct {
+	RequestContext string    `json:"rc"`
+	Time           time.Time `json:"tm"` // Capture time of the earliest record
+	Records        int       `json:"count"`
+	Bytes          int64     `json:"bytes"`
+
+	// The edge request of the request tree, empty until its records arrive
+	Service    string   `json:"service,omitempty"`
+	Method     string   `json:"method,omitempty"`
+	Uri        string   `json:"uri,omitempty"`
+	StatusCode int      `json:"status,omitempty"`
+	Duration   int64    `json:"duration,omitempty"`  // Milliseconds
+	Services   []string `json:"services,omitempty"`  // Every service that recorded a record of the tree
+	ReplayOf   string   `json:"replay_of,omitempty"` // Request context of the capture this one is a recorded replay of
+}
+
+func (i *ContextInfo) add(rec *Record, size int64) {
+	if i.Time.IsZero() || (!rec.Time.IsZero() && rec.Time.Before(i.Time)) {
+		i.Time = rec.Time
+	}
+	i.Records++
+	i.Bytes += size
+	i.ReplayOf = replayOf(rec.RequestContext)
+
+	// Records of the edge request have the request context as cause context
+	if rec.CauseContext == rec.RequestContext {
+		switch rec.RecordType {
+		case RequestRecordType:
+			i.Service, i.Method, i.Uri = rec.ServiceName, rec.Method, rec.Uri
+		case ResponseRecordType:
+			i.StatusCode, i.Duration = rec.StatusCode, rec.Duration
+			if i.Service == "" {
+				i.Service = rec.ServiceName
+			}
+		}
+	}
+	if rec.ServiceName != "" && !slices.Contains(i.Services, rec.ServiceName) {
+		i.Services = append(i.Services, rec.ServiceName)
+	}
+}
+
+// recordSize estimates the memory held by a record
+func recordSize(rec *Record) int64 {
+	size := len(rec.RequestContext) + len(rec.CauseContext) + len(rec.ExecutionContext) + len(rec.DependencyContext) +
+		len(rec.RecordType) + len(rec.Method) + len(rec.ServiceName) + len(rec.ObservationName) + len(rec.Host) + len(rec.Uri) +
+		len(rec.Body) + len(rec.ObservationError) + len(rec.TransportError) + 64
+
+	for name, vals := range rec.Header {
+		size += len(name)
+		for _, v := range vals {
+			size += len(v)
+		}
+	}
+	return int64(size)
+}
+
+type memoryContext struct {
+	records []Record
+	index   recordIndex
+	info    ContextInfo
+}
+
+// memoryShards is the number of independently locked partitions of the memory store.
+// Request contexts are spread over the shards by hash, so ingestion and replays of
+// different request contexts rarely wait for each other.
+const memoryShards = 64
+
+type memoryShard struct {
+	data  map[string]*memoryContext
+	rwMux sync.RWMutex
+}
+
+type memoryStore struct {
+	seed   maphash.Seed
+	shards []memoryShard
+}
+
+func NewMemoryStore() *memoryStore {
+	return newMemoryStore(memoryShards)
+}
+
+func newMemoryStore(shards int) *memoryStore {
+	s := &memoryStore{seed: maphash.MakeSeed(), shards: make([]memoryShard, shards)}
+	for i := range s.shards {
+		s.shards[i].data = make(map[string]*memoryContext)
+	}
+	return s
+}
+
+func (s *memoryStore) shard(rc string) *memoryShard {
+	return &s.shards[maphash.String(s.seed, rc)%uint64(len(s.shards))]
+}
+
+func (s *memoryStore) Append(records []Record) error {
+	// Batches usually hold records of a single request context, so the shard lock is
+	// only taken again when the request context changes
+	var (
+		shard  *memoryShard
+		locked string
+	)
+	for i := range records {
+		rc := records[i].RequestContext
+		if shard == nil || rc != locked {
+			if next := s.shard(rc); next != shard {
+				if shard != nil {
+					shard.rwMux.Unlock()
+				}
+				shard = next
+				shard.rwMux.Lock()
+			}
+			locked = rc
+		}
+
+		c, ok := shard.data[rc]
+		if !ok {
+			c = &memoryContext{index: make(recordIndex), info: ContextInfo{RequestContext: rc}}
+			shard.data[rc] = c
+		}
+		c.index.add(&records[i], len(c.records))
+		c.records = append(c.records, records[i])
+		c.info.add(&records[i], recordSize(&records[i]))
+	}
+	if shard != nil {
+		shard.rwMux.Unlock()
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(rc string) ([]Record, error) {
+	shard := s.shard(rc)
+	shard.rwMux.RLock()
+	defer shard.rwMux.RUnlock()
+
+	c, ok := shard.data[rc]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Callers may hold on to the slice after the lock is released
+	return append([]Record(nil), c.records...), nil
+}
+
+func (s *memoryStore) Find(rc string, key RecordKey) ([]Record, error) {
+	shard := s.shard(rc)
+	shard.rwMux.RLock()
+	defer shard.rwMux.RUnlock()
+
+	c, ok := shard.data[rc]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	positions := c.index[key]
+	records := make([]Record, len(positions))
+	for i, pos := range positions {
+		records[i] = c.records[pos]
+	}
+	return records, nil
+}
+
+func (s *memoryStore) Delete(rc string) error {
+	shard := s.shard(rc)
+	shard.rwMux.Lock()
+	defer shard.rwMux.Unlock()
+
+	delete(shard.data, rc)
+	return nil
+}
+
+func (s *memoryStore) Contexts() []ContextInfo {
+	infos := make([]ContextInfo, 0)
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.rwMux.RLock()
+		for _, c := range shard.data {
+			infos = append(infos, c.info)
+		}
+		shard.rwMux.RUnlock()
+	}
+	return infos
+}
+
+func (s *memoryStore) Rewrite(transform func(Record) (Record, error)) error {
+	for i := range s.shards {
+		if err := s.shards[i].rewrite(transform); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (shard *memoryShard) rewrite(transform func(Record) (Record, error)) error {
+	shard.rwMux.Lock()
+	defer shard.rwMux.Unlock()
+
+	for _, c := range shard.data {
+		info := ContextInfo{RequestContext: c.info.RequestContext}
+		index := make(recordIndex, len(c.index))
+		for i := range c.records {
+			rec, err := transform(c.records[i])
+			if err != nil {
+				return err
+			}
+			c.records[i] = rec
+			index.add(&c.records[i], i)
+			info.add(&c.records[i], recordSize(&c.records[i]))
+		}
+		c.index, c.info = index, info
+	}
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// benchmarkStore replays and ingests request trees of 20 records from all cores at once,
+// readPercent of the operations are replays
+func benchmarkStore(b *testing.B, s Store, readPercent int) {
+	const contexts = 1024
+
+	for i := range contexts {
+		if err := s.Append(testRecords(fmt.Sprintf("rc-%d", i), 20)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	var next atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := next.Add(1)
+			if int(n%100) < readPercent {
+				if _, err := s.Get(fmt.Sprintf("rc-%d", n%contexts)); err != nil {
+					b.Error(err)
+				}
+			} else if err := s.Append(testRecords(fmt.Sprintf("new-%d", n), 20)); err != nil {
+				b.Error(err)
+			}
+		}
+	})
+}
+
+// A single shard is the baseline, every request context is behind one global lock
+
+func BenchmarkMemoryStore(b *testing.B) {
+	for _, shards := range []int{1, memoryShards} {
+		for _, readPercent := range []int{0, 50, 90} {
+			b.Run(fmt.Sprintf("shards=%d/read=%d%%", shards, readPercent), func(b *testing.B) {
+				benchmarkStore(b, newMemoryStore(shards), readPercent)
+			})
+		}
+	}
+}
+
+func BenchmarkSegmentStore(b *testing.B) {
+	for _, shards := range []int{1, segmentShards} {
+		for _, readPercent := range []int{0, 50, 90} {
+			b.Run(fmt.Sprintf("shards=%d/read=%d%%", shards, readPercent), func(b *testing.B) {
+				s, err := openSegmentStore(b.TempDir(), 64<<20, shards)
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer s.Close()
+				benchmarkStore(b, s, readPercent)
+			})
+		}
+	}
+}
+
+func BenchmarkRecordHandler(b *testing.B) {
+	records := testRecords("rc", 500)
+	for i := range records {
+		records[i].Body = bytes.Repeat([]byte("x"), 2048)
+	}
+
+	jsonBody, _ := json.Marshal(records)
+	stream := &bytes.Buffer{}
+	rw := NewRecordWriter(stream)
+	for i := range records {
+		rw.Write(&records[i])
+	}
+
+	for _, c := range []struct {
+		contentType string
+		body        []byte
+	}{
+		{"application/json", jsonBody},
+		{RecordStreamContentType, stream.Bytes()},
+	} {
+		b.Run(c.contentType, func(b *testing.B) {
+			store = NewMemoryStore()
+			defer func() { store = nil }()
+
+			b.SetBytes(int64(len(c.body)))
+			b.ReportAllocs()
+			for b.Loop() {
+				r := httptest.NewRequest("POST", "/runtime/record", bytes.NewReader(c.body))
+				r.Header.Set("Content-Type", c.contentType)
+				w := httptest.NewRecorder()
+				recordHandler(w, r)
+				if w.Code != http.StatusAccepted {
+					b.Fatalf("Want %v Actual %v\n", http.StatusAccepted, w.Code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+)
+
+func checkFind(t *testing.T, s Store) {
+	t.Helper()
+
+	records := testRecords("a", 6)
+	for i := range records {
+		records[i].Uri = "/dep"
+		records[i].ScopedSequence = i % 3
+	}
+	records = append(records, Record{RequestContext: "a", ExecutionContext: "ec-2", RecordType: RequestRecordType})
+	if err := s.Append(records); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := s.Find("a", RecordKey{RecordType: DependencyResponseRecordType, ExecutionContext: "ec", Uri: "/dep", ScopedSequence: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 2 || found[0].DepencencySequence != 1 || found[1].DepencencySequence != 4 {
+		t.Errorf("Want %v Actual %v\n", []int{1, 4}, found)
+	}
+
+	found, err = s.Find("a", RecordKey{RecordType: RequestRecordType, ExecutionContext: "ec-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 {
+		t.Errorf("Want %v Actual %v\n", 1, len(found))
+	}
+
+	if _, err := s.Find("b", RecordKey{}); err != ErrNotFound {
+		t.Errorf("Want %v Actual %v\n", ErrNotFound, err)
+	}
+}
+
+func TestMemoryStoreFind(t *testing.T) {
+	checkFind(t, NewMemoryStore())
+}
+
+func TestSegmentStoreFind(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := OpenSegmentStore(dir, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Append(testRecords("c", 2))
+	checkFind(t, s)
+
+	// The index survives compaction and a restart
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	s.Delete("a")
+	s.Close()
+
+	if s, err = OpenSegmentStore(dir, 64); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkFind(t, s)
+}
+
+func TestBuildRequestTree(t *testing.T) {
+	records := []Record{
+		{RequestContext: "rc", CauseContext: "rc", ExecutionContext: "edge", RecordType: RequestRecordType},
+		{RequestContext: "rc", ExecutionContext: "edge", RecordType: DependencyRequestRecordType, DependencyContext: "dep", DepencencySequence: 0},
+		{RequestContext: "rc", CauseContext: "edge", ExecutionContext: "dep", RecordType: RequestRecordType, ServiceName: "ServiceB"},
+		{RequestContext: "rc", ExecutionContext: "dep", RecordType: ObservedRecordType, ObservationSequence: 0},
+		{RequestContext: "rc", ExecutionContext: "dep", RecordType: ResponseRecordType, StatusCode: 200},
+		{RequestContext: "rc", ExecutionContext: "edge", RecordType: DependencyResponseRecordType, DepencencySequence: 0, StatusCode: 200},
+		{RequestContext: "rc", ExecutionContext: "edge", RecordType: ResponseRecordType, StatusCode: 201},
+	}
+
+	req := buildRequestTree(records, "edge")
+	if req.Out.StatusCode != 201 || len(req.Dependencies) != 1 {
+		t.Fatalf("Want %v Actual %v\n", 201, req.Out.StatusCode)
+	}
+	ref := req.Dependencies[0].Reference
+	if ref.In.ServiceName != "ServiceB" || ref.Out.StatusCode != 200 || len(ref.Observations) != 1 {
+		t.Errorf("Want %v Actual %v\n", "ServiceB", ref)
+	}
+}
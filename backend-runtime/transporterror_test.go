@@ -0,0 +1,24 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxyTransportError(t *testing.T) {
+	store = NewMemoryStore()
+	defer func() { store = nil }()
+
+	store.Append([]Record{
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyRequestRecordType, ServiceName: "ServiceA", Method: "POST", Uri: "http://b/count"},
+		{RequestContext: "rc", ExecutionContext: "e1", RecordType: DependencyResponseRecordType, Method: "POST", Uri: "http://b/count", TransportError: "refused: connection refused"},
+	})
+
+	w := httptest.NewRecorder()
+	proxyHandler(w, proxyRequest("rc", "e1", "http://b/count", "", "", "0"))
+	if w.Code != http.StatusBadGateway || w.Header().Get(TransportErrorHeader) != "refused: connection refused" {
+		t.Errorf("Want %v Actual %v %v\n", "refused: connection refused", w.Code, w.Header())
+	}
+
+}
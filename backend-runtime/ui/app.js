@@ -0,0 +1,368 @@
+"use strict";
+
+// Token of the runtime, asked for when the runtime answers 401 and kept for the browser session
+let token = sessionStorage.getItem("replay-token") || "";
+
+function askToken() {
+    const value = prompt("Runtime token", token);
+    if (value !== null) {
+        token = value.trim();
+        sessionStorage.setItem("replay-token", token);
+    }
+}
+
+async function api(path, options = {}) {
+    for (;;) {
+        const headers = Object.assign({}, options.headers);
+        if (token) {
+            headers["Authorization"] = "Bearer " + token;
+        }
+        const resp = await fetch(path, Object.assign({}, options, { headers }));
+        if (resp.status === 401) {
+            const previous = token;
+            askToken();
+            if (token !== previous) {
+                continue;
+            }
+        }
+        if (!resp.ok) {
+            throw new Error(path + " failed with status " + resp.status);
+        }
+        return resp.json();
+    }
+}
+
+function el(tag, props = {}, ...children) {
+    const e = document.createElement(tag);
+    Object.assign(e, props);
+    for (const child of children) {
+        e.append(child);
+    }
+    return e;
+}
+
+function statusClass(status) {
+    return status ? "status-" + String(status)[0] : "";
+}
+
+// Bodies are base64 encoded []byte
+function decodeBase64(data) {
+    return Uint8Array.from(atob(data), c => c.charCodeAt(0));
+}
+
+function decodeBody(data) {
+    if (!data) {
+        return "";
+    }
+    const text = new TextDecoder().decode(decodeBase64(data));
+    try {
+        return JSON.stringify(JSON.parse(text), null, 2);
+    } catch {
+        return text;
+    }
+}
+
+// decodeObservation guesses how an observed value was encoded, like the show command of the cli. The SDK writes
+// strings as they are, numbers and bools little endian and everything else with gob, which can't be decoded without the type.
+function decodeObservation(data) {
+    if (!data) {
+        return "<empty>";
+    }
+    const bytes = decodeBase64(data);
+
+    try {
+        const text = new TextDecoder("utf-8", { fatal: true }).decode(bytes);
+        if (/^[\p{L}\p{M}\p{N}\p{P}\p{S}\s]*$/u.test(text)) {
+            return JSON.stringify(text);
+        }
+    } catch {
+        // Not text
+    }
+
+    const view = new DataView(bytes.buffer);
+    switch (bytes.length) {
+        case 1:
+            return String(view.getInt8(0));
+        case 2:
+            return String(view.getInt16(0, true));
+        case 4: {
+            const n = view.getInt32(0, true);
+            const f = view.getFloat32(0, true);
+            if ((n > 1 << 24 || n < -(1 << 24)) && Number.isFinite(f)) {
+                // The shortest decimal that reads back as the same float32
+                for (let precision = 1; precision < 9; precision++) {
+                    const short = Number(f.toPrecision(precision));
+                    if (Math.fround(short) === f) {
+                        return String(short);
+                    }
+                }
+                return String(f);
+            }
+            return String(n);
+        }
+        case 8: {
+            const n = view.getBigInt64(0, true);
+            const f = view.getFloat64(0, true);
+            if ((n > 1n << 53n || n < -(1n << 53n)) && Number.isFinite(f)) {
+                return String(f);
+            }
+            return n.toString();
+        }
+    }
+
+    const hex = Array.from(bytes.slice(0, 64), b => b.toString(16).padStart(2, "0")).join("");
+    return "<" + bytes.length + " bytes> " + hex + (bytes.length > 64 ? "..." : "");
+}
+
+async function search(event) {
+    if (event) {
+        event.preventDefault();
+    }
+
+    const form = new FormData(document.getElementById("search"));
+    const params = new URLSearchParams();
+    for (const name of ["service", "route", "status", "text"]) {
+        if (form.get(name)) {
+            params.set(name, form.get(name));
+        }
+    }
+    const since = { "15m": 15 * 60e3, "1h": 3600e3, "24h": 24 * 3600e3 }[form.get("since")];
+    if (since) {
+        params.set("from", new Date(Date.now() - since).toISOString().replace(/\.\d+Z$/, "Z"));
+    }
+
+    const list = document.getElementById("results");
+    list.replaceChildren();
+    let results;
+    try {
+        results = await api("/runtime/search?" + params);
+    } catch (err) {
+        list.append(el("li", { textContent: err.message }));
+        return;
+    }
+    if (results.length === 0) {
+        list.append(el("li", { textContent: "No matching captures" }));
+    }
+
+    for (const r of results) {
+        const item = el("li", {},
+            el("div", {},
+                el("strong", { textContent: r.service || "[Incomplete]" }),
+                " " + (r.method || "") + " " + (r.uri || "") + " ",
+                el("span", { className: statusClass(r.status), textContent: r.status ? "(" + r.status + ")" : "" })),
+            el("div", { className: "meta", textContent: new Date(r.tm).toLocaleString() + " · " + (r.duration || 0) + "ms · " + r.rc }));
+        item.onclick = () => {
+            list.querySelectorAll(".selected").forEach(e => e.classList.remove("selected"));
+            item.classList.add("selected");
+            showCapture(r.rc);
+        };
+        list.append(item);
+    }
+}
+
+// flatten turns the request tree into waterfall rows, depth first
+function flatten(request) {
+    const rows = [{
+        depth: 0,
+        kind: "request",
+        service: request.in.sn || request.out.sn,
+        method: request.in.rm,
+        uri: request.in.ru,
+        status: request.out.st,
+        start: Date.parse(request.in.tm || request.out.tm),
+        duration: request.out.dr,
+        in: request.in,
+        out: request.out,
+    }];
+    addChildren(request, 1, rows);
+    return rows;
+}
+
+function addChildren(request, depth, rows) {
+    for (const ob of request.ob || []) {
+        rows.push({
+            depth,
+            kind: "observation",
+            service: ob.on + "[" + ob.sq + "]",
+            start: Date.parse(ob.tm),
+            duration: 0,
+            in: ob,
+        });
+    }
+    for (const dep of request.dep || []) {
+        rows.push({
+            depth,
+            kind: "dependency",
+            service: (dep.ref && dep.ref.in.sn) || "[External]",
+            method: dep.in.rm,
+            uri: dep.in.ru,
+            // Calls that failed without a response only have the transport error
+            status: dep.out.st || (dep.out.te ? "error" : 0),
+            start: Date.parse(dep.in.tm || dep.out.tm),
+            duration: dep.out.dr,
+            in: dep.in,
+            out: dep.out,
+        });
+        if (dep.ref) {
+            addChildren(dep.ref, depth + 1, rows);
+        }
+    }
+}
+
+function collectServices(request, services) {
+    if (request.in.sn) {
+        services.add(request.in.sn);
+    }
+    for (const dep of request.dep || []) {
+        if (dep.ref) {
+            collectServices(dep.ref, services);
+        }
+    }
+    return services;
+}
+
+let currentCapture = "";
+
+async function showCapture(rc) {
+    let request;
+    try {
+        request = await api("/runtime/replay?rc=" + encodeURIComponent(rc));
+    } catch (err) {
+        alert(err.message);
+        return;
+    }
+
+    currentCapture = rc;
+    document.getElementById("capture").hidden = false;
+    document.getElementById("title").textContent = rc;
+    document.getElementById("node").replaceChildren();
+    document.getElementById("replayResults").replaceChildren();
+
+    const rows = flatten(request);
+    const starts = rows.map(r => r.start).filter(t => !isNaN(t));
+    const t0 = Math.min(...starts);
+    const end = Math.max(...rows.map(r => (isNaN(r.start) ? t0 : r.start) + (r.duration || 0)));
+    const total = Math.max(end - t0, 1);
+
+    const waterfall = document.getElementById("waterfall");
+    waterfall.replaceChildren();
+    for (const row of rows) {
+        const offset = isNaN(row.start) ? 0 : row.start - t0;
+        const bar = el("div", { className: "bar" + (row.kind === "observation" ? " observation" : "") });
+        bar.style.left = (100 * offset / total) + "%";
+        if (row.kind !== "observation") {
+            bar.style.width = (100 * (row.duration || 0) / total) + "%";
+        }
+
+        const label = el("div", { className: "label" },
+            " ".repeat(row.depth * 4) + row.service + " ",
+            el("span", { className: "meta", textContent: (row.method || "") + " " + (row.uri || "") }),
+            el("span", { className: statusClass(row.status), textContent: row.status ? " (" + row.status + ")" : "" }));
+        label.title = (row.duration || 0) + "ms";
+
+        const line = el("div", { className: "row" }, label, el("div", { className: "track" }, bar));
+        line.onclick = () => {
+            waterfall.querySelectorAll(".selected").forEach(e => e.classList.remove("selected"));
+            line.classList.add("selected");
+            showNode(row);
+        };
+        waterfall.append(line);
+    }
+
+    const mapping = document.getElementById("mapping");
+    mapping.replaceChildren();
+    for (const service of collectServices(request, new Set())) {
+        mapping.append(el("label", {}, el("span", { textContent: service }), el("input", { name: service, placeholder: "localhost:3000" })));
+    }
+}
+
+function headerTable(header) {
+    const table = el("table");
+    for (const [name, vals] of Object.entries(header || {})) {
+        for (const v of vals) {
+            table.append(el("tr", {}, el("td", { textContent: name }), el("td", { textContent: v })));
+        }
+    }
+    return table;
+}
+
+function recordSection(title, rec, decode = decodeBody) {
+    const section = el("div", {}, el("h4", { textContent: title }));
+    section.append(headerTable(rec.he));
+    const body = decode(rec.bd);
+    if (body) {
+        section.append(el("pre", { textContent: body }));
+    }
+    const obsError = decodeBody(rec.oe);
+    if (obsError) {
+        section.append(el("h4", { textContent: "Error" }), el("pre", { textContent: obsError }));
+    }
+    if (rec.te) {
+        section.append(el("h4", { textContent: "Transport error" }), el("pre", { textContent: rec.te }));
+    }
+    return section;
+}
+
+function showNode(row) {
+    const node = document.getElementById("node");
+    node.replaceChildren(el("h3", { textContent: row.service + " " + (row.method || "") + " " + (row.uri || "") }));
+    if (row.kind === "observation") {
+        node.append(recordSection("Observed value", row.in, decodeObservation));
+        return;
+    }
+    node.append(el("p", { className: "meta", textContent: "Took " + (row.duration || 0) + "ms, status " + (row.status || "-") }));
+    node.append(recordSection("Request", row.in));
+    if (row.out) {
+        node.append(recordSection("Response", row.out));
+    }
+}
+
+async function replay(event) {
+    event.preventDefault();
+
+    const mapping = {};
+    for (const input of document.querySelectorAll("#mapping input")) {
+        if (input.value.trim()) {
+            mapping[input.name] = input.value.trim();
+        }
+    }
+    const results = document.getElementById("replayResults");
+    results.replaceChildren();
+    if (Object.keys(mapping).length === 0) {
+        results.append(el("p", { textContent: "No service mapping to replay" }));
+        return;
+    }
+
+    results.append(el("p", { textContent: "Replaying..." }));
+    let replayed;
+    try {
+        replayed = await api("/runtime/replay/run", {
+            method: "POST",
+            headers: { "Content-Type": "application/json" },
+            body: JSON.stringify({ rc: currentCapture, mapping }),
+        });
+    } catch (err) {
+        results.replaceChildren(el("p", { textContent: err.message }));
+        return;
+    }
+
+    results.replaceChildren();
+    if (replayed.length === 0) {
+        results.append(el("p", { textContent: "No replayable service mapping" }));
+    }
+    for (const r of replayed) {
+        const section = el("div", {}, el("h4", {}, r.service + " " + r.url + " ",
+            el("span", { className: statusClass(r.status), textContent: r.status ? "(" + r.status + ")" : "" })));
+        if (r.error) {
+            section.append(el("pre", { textContent: r.error }));
+        } else {
+            section.append(headerTable(r.header), el("pre", { textContent: decodeBody(r.body) }));
+        }
+        results.append(section);
+    }
+}
+
+document.getElementById("token").onclick = askToken;
+document.getElementById("search").onsubmit = search;
+document.getElementById("replay").onsubmit = replay;
+search();
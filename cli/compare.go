@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// NodeDifference lists how a call of two request trees differs
+type NodeDifference struct {
+	Node        string // Calls leading to the node from the root, joined with >
+	Divergences []Divergence
+}
+
+// compareRequests diffs two request trees node by node, like a capture and a recorded replay of it.
+// Dependency calls are paired by URI and scoped sequence, the subtrees of paired calls are compared
+// when both trees have them, services frozen during a replay only have the served snapshot.
+func compareRequests(a, b Request) []NodeDifference {
+	return compareNode(nodeLabel(a, a.In, -1), a, b, a.In, a.Out, b.In, b.Out, make([]NodeDifference, 0))
+}
+
+func compareNode(node string, a, b Request, inA, outA, inB, outB Record, diffs []NodeDifference) []NodeDifference {
+	divergences := make([]Divergence, 0)
+	if !strings.EqualFold(inA.Method, inB.Method) {
+		divergences = append(divergences, Divergence{"method", inA.Method, inB.Method})
+	}
+	for _, d := range diffBody(inA.Body, inB.Body, inA.Redacted) {
+		d.Path = "request " + d.Path
+		divergences = append(divergences, d)
+	}
+	divergences = append(divergences, diffResponse(outA, outB.StatusCode, outB.Header, outB.Body)...)
+	if outA.TransportError != outB.TransportError {
+		divergences = append(divergences, Divergence{"transport error", orMissing(outA.TransportError), orMissing(outB.TransportError)})
+	}
+	divergences = append(divergences, compareObservations(a.Observations, b.Observations)...)
+	if len(divergences) > 0 {
+		diffs = append(diffs, NodeDifference{node, divergences})
+	}
+
+	used := make([]bool, len(b.Dependencies))
+	for _, depA := range a.Dependencies {
+		child := node + " > " + nodeLabel(depA.Reference, depA.In, depA.In.ScopedSequence)
+
+		matched := -1
+		for i, depB := range b.Dependencies {
+			if !used[i] && depB.In.Uri == depA.In.Uri && depB.In.ScopedSequence == depA.In.ScopedSequence {
+				matched = i
+				break
+			}
+		}
+		if matched == -1 {
+			diffs = append(diffs, NodeDifference{child, []Divergence{{"call", "made", missingValue}}})
+			continue
+		}
+		used[matched] = true
+
+		depB := b.Dependencies[matched]
+		refA, refB := depA.Reference, depB.Reference
+		if refA.In.ExecutionContext == "" || refB.In.ExecutionContext == "" {
+			// Only one side has the subtree, compare the calls alone
+			refA, refB = Request{}, Request{}
+		}
+		diffs = compareNode(child, refA, refB, depA.In, depA.Out, depB.In, depB.Out, diffs)
+	}
+
+	for i, depB := range b.Dependencies {
+		if !used[i] {
+			child := node + " > " + nodeLabel(depB.Reference, depB.In, depB.In.ScopedSequence)
+			diffs = append(diffs, NodeDifference{child, []Divergence{{"call", missingValue, "made"}}})
+		}
+	}
+	return diffs
+}
+
+func nodeLabel(request Request, in Record, seq int) string {
+	serviceName := request.Out.ServiceName
+	if serviceName == "" {
+		serviceName = "[External]"
+	}
+	if seq < 0 {
+		return fmt.Sprintf("%s %s %s", serviceName, in.Method, in.Uri)
+	}
+	return fmt.Sprintf("%s %s %s [%d]", serviceName, in.Method, in.Uri, seq)
+}
+
+func compareObservations(a, b []Record) []Divergence {
+	key := func(ob Record) string { return fmt.Sprintf("%s[%d]", ob.ObservationName, ob.ScopedSequence) }
+
+	byKey := make(map[string]Record, len(b))
+	for _, ob := range b {
+		byKey[key(ob)] = ob
+	}
+
+	divergences := make([]Divergence, 0)
+	for _, obA := range a {
+		k := key(obA)
+		obB, ok := byKey[k]
+		delete(byKey, k)
+		switch {
+		case !ok:
+			divergences = append(divergences, Divergence{"observation " + k, decodeObservation(obA.Body), missingValue})
+		case !bytes.Equal(obA.Body, obB.Body):
+			divergences = append(divergences, Divergence{"observation " + k, decodeObservation(obA.Body), decodeObservation(obB.Body)})
+		case !bytes.Equal(obA.ObservationError, obB.ObservationError):
+			divergences = append(divergences, Divergence{"observation " + k + " error", preview(obA.ObservationError), preview(obB.ObservationError)})
+		}
+	}
+	for _, obB := range b {
+		if _, ok := byKey[key(obB)]; ok {
+			divergences = append(divergences, Divergence{"observation " + key(obB), missingValue, decodeObservation(obB.Body)})
+		}
+	}
+	return divergences
+}
+
+func printNodeDifferences(diffs []NodeDifference) {
+	if len(diffs) == 0 {
+		fmt.Println("The request trees match")
+		return
+	}
+
+	fmt.Printf("%d calls differ\n", len(diffs))
+	for _, diff := range diffs {
+		fmt.Printf("\n%s\n", diff.Node)
+		for _, d := range diff.Divergences {
+			fmt.Printf("    %s: %s -> %s\n", d.Path, d.Recorded, d.Replayed)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompareRequests(t *testing.T) {
+	capture := Request{
+		In:  Record{ExecutionContext: "e1", Method: "GET", Uri: "/boost"},
+		Out: Record{ServiceName: "ServiceA", StatusCode: 500, Body: []byte(`{"count": 1}`)},
+		Observations: []Record{
+			{ObservationName: "Name", Body: []byte("a")},
+		},
+		Dependencies: []Dependency{
+			{
+				In:  Record{Method: "POST", Uri: "http://b/count", Body: []byte(`{"n": 1}`)},
+				Out: Record{StatusCode: 200},
+				Reference: Request{
+					In:           Record{ExecutionContext: "e2"},
+					Out:          Record{ServiceName: "ServiceB"},
+					Observations: []Record{{ObservationName: "Name", Body: []byte("b")}},
+				},
+			},
+			{
+				In:  Record{Method: "GET", Uri: "http://c/stock"},
+				Out: Record{StatusCode: 200},
+			},
+		},
+	}
+
+	replay := Request{
+		In:  Record{ExecutionContext: "e1", Method: "GET", Uri: "/boost"},
+		Out: Record{ServiceName: "ServiceA", StatusCode: 200, Body: []byte(`{"count": 2}`)},
+		Observations: []Record{
+			{ObservationName: "Name", Body: []byte("a")},
+		},
+		Dependencies: []Dependency{
+			{
+				In:  Record{Method: "POST", Uri: "http://b/count", Body: []byte(`{"n": 2}`)},
+				Out: Record{StatusCode: 200},
+				Reference: Request{
+					In:           Record{ExecutionContext: "e2"},
+					Out:          Record{ServiceName: "ServiceB"},
+					Observations: []Record{{ObservationName: "Name", Body: []byte("c")}},
+				},
+			},
+			{
+				In:  Record{Method: "GET", Uri: "http://d/price"},
+				Out: Record{StatusCode: 200},
+			},
+		},
+	}
+
+	want := []NodeDifference{
+		{"ServiceA GET /boost", []Divergence{{"status", "500", "200"}, {"body.count", "1", "2"}}},
+		{"ServiceA GET /boost > ServiceB POST http://b/count [0]", []Divergence{{"request body.n", "1", "2"}, {"observation Name[0]", `"b"`, `"c"`}}},
+		{"ServiceA GET /boost > [External] GET http://c/stock [0]", []Divergence{{"call", "made", missingValue}}},
+		{"ServiceA GET /boost > [External] GET http://d/price [0]", []Divergence{{"call", missingValue, "made"}}},
+	}
+
+	act := compareRequests(capture, replay)
+	if !reflect.DeepEqual(want, act) {
+		t.Errorf("Want %v Actual %v\n", want, act)
+	}
+
+	if act := compareRequests(capture, capture); len(act) != 0 {
+		t.Errorf("Want %v Actual %v\n", 0, act)
+	}
+}
+
+func TestCompareTransportError(t *testing.T) {
+	capture := Request{
+		In: Record{ExecutionContext: "e1", Method: "GET", Uri: "/boost"},
+		Dependencies: []Dependency{
+			{In: Record{Method: "GET", Uri: "http://b/count"}, Out: Record{TransportError: "refused: connection refused"}},
+		},
+	}
+	replay := Request{
+		In: Record{ExecutionContext: "e1", Method: "GET", Uri: "/boost"},
+		Dependencies: []Dependency{
+			{In: Record{Method: "GET", Uri: "http://b/count"}, Out: Record{TransportError: "timeout: i/o timeout"}},
+		},
+	}
+
+	want := []NodeDifference{
+		{"[External] GET /boost > [External] GET http://b/count [0]", []Divergence{{"transport error", "refused: connection refused", "timeout: i/o timeout"}}},
+	}
+	if act := compareRequests(capture, replay); !reflect.DeepEqual(want, act) {
+		t.Errorf("Want %v Actual %v\n", want, act)
+	}
+}
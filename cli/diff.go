@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers expected to change between the capture and a replay
+var volatileHeaders = map[string]bool{
+	"Date":               true,
+	"Content-Length":     true,
+	"X-Capture":          true,
+	RequestContextHeader: true,
+}
+
+const missingValue = "<missing>"
+
+// Divergence is a difference between the recorded and the replayed response
+type Divergence struct {
+	Path     string
+	Recorded string
+	Replayed string
+}
+
+// diffResponse compares a replayed response with the recorded one. Values redacted by the SDK
+// can't be compared and are skipped, JSON bodies are compared field by field.
+func diffResponse(recorded Record, status int, header http.Header, body []byte) []Divergence {
+	divergences := make([]Divergence, 0)
+
+	if recorded.StatusCode != status {
+		divergences = append(divergences, Divergence{"status", strconv.Itoa(recorded.StatusCode), strconv.Itoa(status)})
+	}
+
+	// Only headers set by the service are recorded, the ones added by the HTTP server aren't compared
+	recordedHeader := http.Header(recorded.Header)
+	sorted := make([]string, 0, len(recordedHeader))
+	for name := range recordedHeader {
+		if !volatileHeaders[http.CanonicalHeaderKey(name)] {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		want, got := strings.Join(recordedHeader.Values(name), ", "), strings.Join(header.Values(name), ", ")
+		if strings.HasPrefix(want, "redacted:") {
+			continue
+		}
+		if !redactedMatch(want, got) {
+			divergences = append(divergences, Divergence{"header " + name, orMissing(want), orMissing(got)})
+		}
+	}
+
+	return append(divergences, diffBody(recorded.Body, body, recorded.Redacted)...)
+}
+
+func orMissing(value string) string {
+	if value == "" {
+		return missingValue
+	}
+	return value
+}
+
+func decodeJSON(body []byte) (any, bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// diffBody compares the bodies, redacted are the paths the SDK redacted in the recorded body
+func diffBody(recorded, replayed []byte, redacted []string) []Divergence {
+	recordedValue, recordedOk := decodeJSON(recorded)
+	replayedValue, replayedOk := decodeJSON(replayed)
+	if recordedOk && replayedOk {
+		skip := make(map[string]bool, len(redacted))
+		for _, path := range redacted {
+			skip[path] = true
+		}
+		return diffJSON("body", "", recordedValue, replayedValue, skip, make([]Divergence, 0))
+	}
+
+	if redactedMatch(string(bytes.TrimSpace(recorded)), string(bytes.TrimSpace(replayed))) {
+		return nil
+	}
+	return []Divergence{{"body", preview(recorded), preview(replayed)}}
+}
+
+// diffJSON compares the values at path, at is the same path the way the SDK reports redacted paths, like items.0.card
+func diffJSON(path, at string, recorded, replayed any, redacted map[string]bool, divergences []Divergence) []Divergence {
+	if redacted[at] {
+		return divergences
+	}
+
+	switch r := recorded.(type) {
+	case string:
+		if p, ok := replayed.(string); ok && (strings.HasPrefix(r, "redacted:") || redactedMatch(r, p)) {
+			return divergences
+		}
+	case map[string]any:
+		if p, ok := replayed.(map[string]any); ok {
+			keys := make(map[string]bool, len(r)+len(p))
+			for k := range r {
+				keys[k] = true
+			}
+			for k := range p {
+				keys[k] = true
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+
+			for _, k := range sorted {
+				rv, rok := r[k]
+				pv, pok := p[k]
+				switch {
+				case !rok:
+					divergences = append(divergences, Divergence{path + "." + k, missingValue, jsonString(pv)})
+				case !pok:
+					divergences = append(divergences, Divergence{path + "." + k, jsonString(rv), missingValue})
+				default:
+					divergences = diffJSON(path+"."+k, joinPath(at, k), rv, pv, redacted, divergences)
+				}
+			}
+			return divergences
+		}
+	case []any:
+		if p, ok := replayed.([]any); ok {
+			for i := range max(len(r), len(p)) {
+				elemPath := fmt.Sprintf("%s[%d]", path, i)
+				switch {
+				case i >= len(r):
+					divergences = append(divergences, Divergence{elemPath, missingValue, jsonString(p[i])})
+				case i >= len(p):
+					divergences = append(divergences, Divergence{elemPath, jsonString(r[i]), missingValue})
+				default:
+					divergences = diffJSON(elemPath, joinPath(at, strconv.Itoa(i)), r[i], p[i], redacted, divergences)
+				}
+			}
+			return divergences
+		}
+	}
+
+	if want, got := jsonString(recorded), jsonString(replayed); want != got {
+		divergences = append(divergences, Divergence{path, want, got})
+	}
+	return divergences
+}
+
+func joinPath(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+// redactionToken is what the SDK replaces a redacted value with
+var redactionToken = regexp.MustCompile(`redacted:[0-9a-f]{24}`)
+
+// redactedMatch compares recorded text with the replayed text, a redaction token in the
+// recorded text stands for any text, like a scrubbed card number within a message
+func redactedMatch(recorded, replayed string) bool {
+	parts := redactionToken.Split(recorded, -1)
+	if len(parts) == 1 {
+		return recorded == replayed
+	}
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	matched, _ := regexp.MatchString(`(?s)^`+strings.Join(parts, ".*")+`$`, replayed)
+	return matched
+}
+
+func jsonString(value any) string {
+	data, _ := json.Marshal(value)
+	return preview(data)
+}
+
+func preview(data []byte) string {
+	if len(data) == 0 {
+		return missingValue
+	}
+	if len(data) > 80 {
+		return string(data[:77]) + "..."
+	}
+	return string(data)
+}
+
+func printDivergences(divergences []Divergence) {
+	if len(divergences) == 0 {
+		fmt.Println("Matches the recorded response")
+		return
+	}
+
+	fmt.Printf("%d differences from the recorded response\n", len(divergences))
+	for _, d := range divergences {
+		fmt.Printf("    %s: %s -> %s\n", d.Path, d.Recorded, d.Replayed)
+	}
+}
+
+func getDivergences(rc string, since time.Time) ([]CallDivergence, error) {
+	path := divergencePath + url.QueryEscape(rc) + "&since=" + url.QueryEscape(since.UTC().Format(time.RFC3339Nano))
+	req, err := newRuntimeRequest(http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code: %d", resp.StatusCode)
+	}
+
+	events := make([]CallDivergence, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func printCallDivergences(events []CallDivergence) {
+	if len(events) == 0 {
+		fmt.Println("Every dependency call matched the capture")
+		return
+	}
+
+	fmt.Printf("%d dependency calls diverged from the capture\n", len(events))
+	for _, d := range events {
+		fmt.Printf("    %s call %d to %s, %s: %s -> %s\n", d.ServiceName, d.DepencencySequence, d.Uri, d.Field, d.Recorded, d.Replayed)
+	}
+}
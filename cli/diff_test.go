@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDiffResponse(t *testing.T) {
+	recorded := Record{
+		StatusCode: 200,
+		Header:     map[string][]string{"Content-Type": {"application/json"}, "Set-Cookie": {"redacted:abc"}, "Date": {"yesterday"}},
+		Body:       []byte(`{"count": 42, "items": [{"id": 1}, {"id": 2}], "name": "a"}`),
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}, "Set-Cookie": {"session=1"}, "Date": {"today"}}
+	act := diffResponse(recorded, 500, header, []byte(`{"count": 43, "items": [{"id": 1}], "name": "a", "extra": true}`))
+
+	want := []Divergence{
+		{"status", "200", "500"},
+		{"body.count", "42", "43"},
+		{"body.extra", missingValue, "true"},
+		{"body.items[1]", `{"id":2}`, missingValue},
+	}
+	if len(act) != len(want) {
+		t.Fatalf("Want %v Actual %v\n", want, act)
+	}
+	for i := range want {
+		if act[i] != want[i] {
+			t.Errorf("Want %v Actual %v\n", want[i], act[i])
+		}
+	}
+
+	if act := diffResponse(Record{StatusCode: 200, Body: []byte("ok\n")}, 200, http.Header{}, []byte("ok")); len(act) != 0 {
+		t.Errorf("Want %v Actual %v\n", 0, act)
+	}
+}
+
+func TestDiffResponseRedacted(t *testing.T) {
+	// The SDK replaced the card number with a number, and the card in the note and the session with tokens
+	recorded := Record{
+		StatusCode: 200,
+		Header:     map[string][]string{"X-Session": {"user redacted:0123456789abcdef01234567"}},
+		Body:       []byte(`{"card": 801246913, "note": "paid with redacted:0123456789abcdef01234567 today", "count": 1}`),
+		Redacted:   []string{"card"},
+	}
+
+	header := http.Header{"X-Session": {"user 42"}}
+	act := diffResponse(recorded, 200, header, []byte(`{"card": 4111111111111111, "note": "paid with 4111-1111 today", "count": 2}`))
+	if want := (Divergence{"body.count", "1", "2"}); len(act) != 1 || act[0] != want {
+		t.Errorf("Want %v Actual %v\n", want, act)
+	}
+
+	act = diffResponse(recorded, 200, header, []byte(`{"card": 4111111111111111, "note": "paid with 4111-1111 yesterday", "count": 1}`))
+	if len(act) != 1 || act[0].Path != "body.note" {
+		t.Errorf("Want %v Actual %v\n", "body.note", act)
+	}
+}
@@ -6,11 +6,25 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
-const debugHost = "http://localhost:8080/runtime/replay?rc="
+const (
+	replayPath     = "/runtime/replay?rc="
+	pinPath        = "/runtime/pin?rc="
+	searchPath     = "/runtime/search?"
+	divergencePath = "/runtime/divergences?rc="
+	sessionPath    = "/runtime/sessions?id="
+	planPath       = "/runtime/plans"
+
+	// A recorded replay is captured under the original request context joined with the replay session
+	replayContextSeparator = ".replay."
+)
 
 func main() {
 	input, err := parseInput()
@@ -24,8 +38,14 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		printRequest(request, request.Out.StatusCode, 0)
+		if err := showRequest(request, input.Show); err != nil {
+			panic(err)
+		}
 	case ReplayAction:
+		config, err := replayConfig(&input)
+		if err != nil {
+			panic(err)
+		}
 		if len(input.Mapping) == 0 {
 			fmt.Println("No service mapping to replay")
 			return
@@ -36,24 +56,99 @@ func main() {
 			panic(err)
 		}
 
-		count := replayRequest(request, input.Mapping, 0)
+		session, err := newSessionID()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Replay session %s\n", session)
+
+		options := ReplayOptions{Config: config, Session: session}
+		if input.Record {
+			options.RecordContext = input.RequestContext + replayContextSeparator + session
+		}
+
+		start := time.Now()
+		count := replayRequest(request, input.Mapping, options, 0)
 		if count == 0 {
 			fmt.Println("No replayable service mapping")
+			return
+		}
+
+		events, err := getDivergences(input.RequestContext, start)
+		if err != nil {
+			fmt.Printf("Unable to get divergences: %s\n", err.Error())
+			return
+		}
+		printCallDivergences(events)
+		fmt.Printf("Timeline: dbg session show %s\n", session)
+		if options.RecordContext != "" {
+			fmt.Printf("Recorded as %s, once exported: dbg compare %s %s\n", options.RecordContext, input.RequestContext, options.RecordContext)
+		}
+	case PinAction:
+		if err := pinRequest(input.RequestContext, http.MethodPost); err != nil {
+			panic(err)
 		}
+		fmt.Printf("Pinned %s\n", input.RequestContext)
+	case UnpinAction:
+		if err := pinRequest(input.RequestContext, http.MethodDelete); err != nil {
+			panic(err)
+		}
+		fmt.Printf("Unpinned %s\n", input.RequestContext)
+	case SearchAction:
+		results, err := searchRequests(input.Filters)
+		if err != nil {
+			panic(err)
+		}
+		printSearchResults(results)
+	case SessionAction:
+		session, err := getSession(input.Session)
+		if err != nil {
+			panic(err)
+		}
+		request, err := getRequest(session.RequestContext)
+		if err != nil {
+			panic(err)
+		}
+		printSession(session, request)
+	case PlanAction:
+		id, err := savePlan(input.PlanFile)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("Saved plan %s, replay it with: dbg replay [request-context] --plan-id %s\n", id, id)
+	case CompareAction:
+		a, err := getRequest(input.RequestContext)
+		if err != nil {
+			panic(err)
+		}
+		b, err := getRequest(input.Compare)
+		if err != nil {
+			panic(err)
+		}
+		printNodeDifferences(compareRequests(a, b))
 	default:
 		fmt.Println("Unknown action")
 	}
 }
 
 func getRequest(rc string) (Request, error) {
-	replayUri := debugHost + rc
+	req, err := newRuntimeRequest(http.MethodGet, replayPath+url.QueryEscape(rc), nil)
+	if err != nil {
+		return Request{}, err
+	}
 
-	resp, err := http.Get(replayUri)
+	resp, err := runtimeClient.Do(req)
 	if err != nil {
 		panic(err)
 	}
-	if resp.StatusCode != http.StatusOK {
-		return Request{}, fmt.Errorf("Coudn't find request %s", rc)
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return Request{}, fmt.Errorf("Not authorized to read request %s, check REPLAY_TOKEN", rc)
+	default:
+		return Request{}, fmt.Errorf("Coudn't find request %s, status code: %d", rc, resp.StatusCode)
 	}
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -68,26 +163,22 @@ func getRequest(rc string) (Request, error) {
 	return request, nil
 }
 
-func getPreposition(level int) string {
-	return strings.Join(make([]string, level+1), "    ")
-}
-
-func printRequest(request Request, statusCode, level int) {
-	pre := getPreposition(level)
-	serviceName := request.Out.ServiceName
-	if serviceName == "" {
-		serviceName = "[External]"
+func pinRequest(rc, method string) error {
+	req, err := newRuntimeRequest(method, pinPath+url.QueryEscape(rc), nil)
+	if err != nil {
+		return err
 	}
-	fmt.Printf("%s-> %s (%d)\n", pre, serviceName, statusCode)
 
-	obPre := getPreposition(level + 1)
-	for i := range request.Observations {
-		fmt.Printf("%s-> Internal <%s[%d]>\n", obPre, request.Observations[i].ObservationName, request.Observations[i].ScopedSequence)
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	for i := range request.Dependencies {
-		printRequest(request.Dependencies[i].Reference, request.Dependencies[i].Out.StatusCode, level+1)
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("Unable to update pin of %s, status code: %d", rc, resp.StatusCode)
 	}
+	return nil
 }
 
 func parseInput() (Input, error) {
@@ -95,6 +186,32 @@ func parseInput() (Input, error) {
 
 	i := Input{
 		Mapping: map[string]string{},
+		Show:    ShowOptions{Depth: -1},
+	}
+
+	if len(args) > 0 && Action(args[0]) == SearchAction {
+		i.Action = SearchAction
+		filters, err := parseSearchFilters(args[1:])
+		i.Filters = filters
+		return i, err
+	}
+
+	if len(args) > 0 && Action(args[0]) == SessionAction {
+		if len(args) != 3 || args[1] != "show" {
+			return i, fmt.Errorf("Usage: session show <id>")
+		}
+		i.Action = SessionAction
+		i.Session = args[2]
+		return i, nil
+	}
+
+	if len(args) > 0 && Action(args[0]) == PlanAction {
+		if len(args) != 3 || args[1] != "save" {
+			return i, fmt.Errorf("Usage: plan save <file>")
+		}
+		i.Action = PlanAction
+		i.PlanFile = args[2]
+		return i, nil
 	}
 
 	if len(args) < 2 {
@@ -106,21 +223,154 @@ func parseInput() (Input, error) {
 
 	args = args[2:]
 
-	if len(args) > 0 && args[0] == "--map" {
+	if i.Action == CompareAction {
+		if len(args) != 1 {
+			return i, fmt.Errorf("Usage: compare <request-context> <request-context>")
+		}
+		i.Compare = args[0]
+		return i, nil
+	}
+
+	// Options configuring the replay, a stored plan is replayed as is
+	replayOptions := make([]string, 0)
+
+	for len(args) > 0 {
+		option := args[0]
 		args = args[1:]
-		for len(args) > 0 {
-			arg := args[0]
+
+		// A plan replaces the replay configuration, the options configuring it go after the plan
+		if option == "--plan" {
+			earlier := slices.DeleteFunc(slices.Clone(replayOptions), func(o string) bool { return o == "--map" })
+			if len(earlier) > 0 {
+				return i, fmt.Errorf("--plan replaces %s given before it", strings.Join(earlier, ", "))
+			}
+		}
+
+		switch option {
+		case "--map", "--fail-on-divergence", "--on-missing", "--stub", "--stub-body", "--latency", "--fault", "--override", "--plan":
+			if !slices.Contains(replayOptions, option) {
+				replayOptions = append(replayOptions, option)
+			}
+		}
+
+		switch option {
+		case "--map":
+			// The mapping takes the arguments up to the next option
+			for len(args) > 0 && !strings.HasPrefix(args[0], "--") {
+				name, host, ok := strings.Cut(args[0], "=")
+				if !ok || name == "" {
+					return i, fmt.Errorf("Invalid mapping %s, expected name=host", args[0])
+				}
+				i.Mapping[strings.ToLower(name)] = host
+				args = args[1:]
+			}
+		case "--verbose":
+			i.Show.Verbose = true
+		case "--headers":
+			i.Show.Headers = true
+		case "--bodies":
+			i.Show.Bodies = true
+		case "--json":
+			i.Show.JSON = true
+		case "--fail-on-divergence":
+			i.Plan.Divergence = "fail"
+		case "--record":
+			i.Record = true
+		case "--depth", "--service", "--on-missing", "--stub", "--stub-body", "--latency", "--fault", "--override", "--plan", "--plan-id":
+			if len(args) == 0 {
+				return i, fmt.Errorf("Missing value of %s", option)
+			}
+			value := args[0]
 			args = args[1:]
-			sh := strings.Split(arg, "=")
-			if len(sh) == 2 {
-				i.Mapping[strings.ToLower(sh[0])] = sh[1]
+
+			switch option {
+			case "--service":
+				i.Show.Service = value
+			case "--on-missing":
+				if value != "fail" && value != "live" && value != "stub" {
+					return i, fmt.Errorf("Invalid missing snapshot policy %s, expected fail, live or stub", value)
+				}
+				i.Plan.Missing = missingPlan(i.Plan.Missing)
+				i.Plan.Missing.Policy = value
+			case "--stub":
+				status, err := strconv.Atoi(value)
+				if err != nil || status < 100 || status > 999 {
+					return i, fmt.Errorf("Invalid stub status %s", value)
+				}
+				i.Plan.Missing = missingPlan(i.Plan.Missing)
+				i.Plan.Missing.Status = status
+			case "--latency":
+				// A factor for every dependency or service=factor for one of them
+				service, factor, ok := strings.Cut(value, "=")
+				if !ok {
+					factor = value
+				}
+				f, err := strconv.ParseFloat(factor, 64)
+				if err != nil || f < 0 {
+					return i, fmt.Errorf("Invalid latency factor %s", value)
+				}
+				if i.Plan.Latency == nil {
+					i.Plan.Latency = &LatencyPlan{Services: map[string]float64{}}
+				}
+				if ok {
+					i.Plan.Latency.Services[strings.ToLower(service)] = f
+				} else {
+					i.Plan.Latency.Factor = f
+				}
+			case "--fault":
+				fault, err := faultPlan(value)
+				if err != nil {
+					return i, err
+				}
+				i.Plan.Faults = append(i.Plan.Faults, fault)
+			case "--override":
+				override, err := overridePlan(value)
+				if err != nil {
+					return i, err
+				}
+				i.Plan.Overrides = append(i.Plan.Overrides, override)
+			case "--stub-body":
+				i.Plan.Missing = missingPlan(i.Plan.Missing)
+				i.Plan.Missing.Body = value
+			case "--plan":
+				// Options after the plan add to it
+				plan, err := readPlan(value)
+				if err != nil {
+					return i, err
+				}
+				for name, host := range plan.Mapping {
+					i.Mapping[strings.ToLower(name)] = host
+				}
+				plan.Mapping = nil
+				i.Plan = plan
+			case "--plan-id":
+				i.PlanID = value
+			default:
+				depth, err := strconv.Atoi(value)
+				if err != nil || depth < 0 {
+					return i, fmt.Errorf("Invalid depth %s", value)
+				}
+				i.Show.Depth = depth
 			}
+		default:
+			return i, fmt.Errorf("Unknown option %s", option)
 		}
 	}
+
+	if i.PlanID != "" && len(replayOptions) > 0 {
+		return i, fmt.Errorf("A stored plan is replayed as is, --plan-id can't be combined with %s", strings.Join(replayOptions, ", "))
+	}
 	return i, nil
 }
 
-func replayRequest(request Request, mapping map[string]string, count int) int {
+// ReplayOptions is what the cli sends along with every replayed request
+type ReplayOptions struct {
+	Config        string // Debug config, the service mapping and replay directives
+	Session       string // Replay session the runtime collects the timeline in
+	RecordContext string // Request context the replay is recorded under, not recorded when empty
+}
+
+func replayRequest(request Request, mapping map[string]string, options ReplayOptions, count int) int {
 	serviceKey := strings.ToLower(request.In.ServiceName)
 
 	if host, ok := mapping[serviceKey]; ok {
@@ -146,7 +396,13 @@ func replayRequest(request Request, mapping map[string]string, count int) int {
 		httpRquest.Header.Set(CauseContextHeader, in.CauseContext)
 		httpRquest.Header.Set(ExecutionContextHeader, in.ExecutionContext)
 		httpRquest.Header.Set(ServiceDebugHeader, DebugEnabled)
-		httpRquest.Header.Set(DebugConfigHeader, debugConfig(mapping))
+		httpRquest.Header.Set(DebugConfigHeader, options.Config)
+		httpRquest.Header.Set(ReplaySessionHeader, options.Session)
+		if options.RecordContext != "" {
+			// The replayed request is the edge of the recorded replay
+			httpRquest.Header.Set(CauseContextHeader, options.RecordContext)
+			httpRquest.Header.Set(ReplayContextHeader, options.RecordContext)
+		}
 
 		resp, err := http.DefaultClient.Do(httpRquest)
 		if err != nil {
@@ -161,27 +417,15 @@ func replayRequest(request Request, mapping map[string]string, count int) int {
 			return count
 		}
 
-		fmt.Printf("Body: %s", string(respBody))
+		fmt.Printf("Body: %s\n", string(respBody))
+		printDivergences(diffResponse(request.Out, resp.StatusCode, resp.Header, respBody))
 	} else {
 		// Only replay dependencies if the request itself isn't replayed
 		for _, dep := range request.Dependencies {
 			if dep.Reference.In.ServiceName != "" {
-				count += replayRequest(dep.Reference, mapping, count)
+				count += replayRequest(dep.Reference, mapping, options, count)
 			}
 		}
 	}
 	return count
 }
-
-func debugConfig(mapping map[string]string) string {
-	retval := ""
-
-	for k, v := range mapping {
-		if retval != "" {
-			retval += "|"
-		}
-		retval += k + "=" + v
-	}
-
-	return retval
-}
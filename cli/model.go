@@ -1,6 +1,10 @@
 package main
 
-import "time"
+import (
+	"encoding/json"
+	"net/url"
+	"time"
+)
 
 const (
 	RequestContextHeader           = "X-Request-Context"
@@ -10,6 +14,8 @@ const (
 	DebugConfigHeader              = "X-Debug-Config"
 	DepencencySequenceHeader       = "X-Dependency-Sequence"
 	ScopedDependencySequenceHeader = "X-Scoped-Dependency-Sequence"
+	ReplaySessionHeader            = "X-Replay-Session"
+	ReplayContextHeader            = "X-Replay-Context"
 
 	DebugEnabled = "ENABLED"
 )
@@ -17,14 +23,28 @@ const (
 type Action string
 
 const (
-	ShowAction   = Action("show")
-	ReplayAction = Action("replay")
+	ShowAction    = Action("show")
+	ReplayAction  = Action("replay")
+	PinAction     = Action("pin")
+	UnpinAction   = Action("unpin")
+	SearchAction  = Action("search")
+	SessionAction = Action("session")
+	CompareAction = Action("compare")
+	PlanAction    = Action("plan")
 )
 
 type Input struct {
 	Action         Action
 	RequestContext string
 	Mapping        map[string]string
+	Plan           ReplayPlan // Replay behaviour sent along with the mapping in the debug config
+	PlanID         string     // Plan stored in the runtime, replayed as is
+	PlanFile       string     // Plan to store in the runtime
+	Filters        url.Values
+	Session        string // Id of the replay session to show
+	Record         bool   // Record the replay as a capture of its own
+	Compare        string // Request context compared with RequestContext
+	Show           ShowOptions
 }
 
 type RecordType string
@@ -55,8 +75,10 @@ type Record struct {
 	Uri                 string              `json:"ru"`
 	Header              map[string][]string `json:"he"`
 	Body                []byte              `json:"bd"`
-	ObservationError    []byte              `json:"oe"`
+	ObservationError    []byte              `json:"oe"` // Error of an observation
 	StatusCode          int                 `json:"st"`
+	Redacted            []string            `json:"rd,omitempty"` // Paths of the JSON body redacted by the SDK, like items.0.card
+	TransportError      string              `json:"te,omitempty"` // Error of a dependency call that got no response, like "refused: dial tcp ..."
 }
 
 type Request struct {
@@ -71,3 +93,94 @@ type Dependency struct {
 	Out       Record  `json:"out"`
 	Reference Request `json:"ref"`
 }
+
+// ContextInfo is a search result of the runtime
+type ContextInfo struct {
+	RequestContext string    `json:"rc"`
+	Time           time.Time `json:"tm"`
+	Records        int       `json:"count"`
+	Bytes          int64     `json:"bytes"`
+	Service        string    `json:"service,omitempty"`
+	Method         string    `json:"method,omitempty"`
+	Uri            string    `json:"uri,omitempty"`
+	StatusCode     int       `json:"status,omitempty"`
+	Duration       int64     `json:"duration,omitempty"`
+	Services       []string  `json:"services,omitempty"`
+	ReplayOf       string    `json:"replay_of,omitempty"`
+}
+
+// CallDivergence is a dependency call made during replay that differs from the recorded call
+type CallDivergence struct {
+	Time               time.Time `json:"tm"`
+	RequestContext     string    `json:"rc"`
+	ExecutionContext   string    `json:"ec"`
+	ServiceName        string    `json:"sn"`
+	Uri                string    `json:"ru"`
+	DepencencySequence int       `json:"dq"`
+	ScopedSequence     int       `json:"sq"`
+	Field              string    `json:"field"`
+	Recorded           string    `json:"recorded"`
+	Replayed           string    `json:"replayed"`
+}
+
+// SessionEvent is something the runtime did for a replay
+type SessionEvent struct {
+	Time             time.Time `json:"tm"`
+	Kind             string    `json:"kind"` // snapshot, forward, rejected or observations
+	ExecutionContext string    `json:"ec"`
+	Caller           string    `json:"caller,omitempty"`
+	ServiceName      string    `json:"sn,omitempty"`
+	Method           string    `json:"rm,omitempty"`
+	Uri              string    `json:"ru,omitempty"`
+	ScopedSequence   int       `json:"sq"`
+	StatusCode       int       `json:"st,omitempty"`
+	Duration         int64     `json:"dr"`
+	Divergence       string    `json:"divergence,omitempty"`
+	Detail           string    `json:"detail,omitempty"`
+}
+
+// ReplaySession is the timeline the runtime collected during a replay
+type ReplaySession struct {
+	ID             string         `json:"id"`
+	RequestContext string         `json:"rc"`
+	Started        time.Time      `json:"started"`
+	Events         []SessionEvent `json:"events"`
+}
+
+// ReplayPlan is the debug config of a replay, sent inline or stored in the runtime
+type ReplayPlan struct {
+	Version    int               `json:"version"`
+	Mapping    map[string]string `json:"mapping,omitempty"`
+	Pass       []string          `json:"pass,omitempty"`
+	Divergence string            `json:"divergence,omitempty"`
+	Missing    *MissingPlan      `json:"missing,omitempty"`
+	Latency    *LatencyPlan      `json:"latency,omitempty"`
+	Faults     []FaultPlan       `json:"faults,omitempty"`
+	Overrides  []OverridePlan    `json:"overrides,omitempty"`
+}
+
+type MissingPlan struct {
+	Policy string `json:"policy"`
+	Status int    `json:"status,omitempty"`
+	Body   string `json:"body,omitempty"`
+}
+
+type LatencyPlan struct {
+	Factor   float64            `json:"factor,omitempty"`
+	Services map[string]float64 `json:"services,omitempty"`
+}
+
+type FaultPlan struct {
+	Target   string                     `json:"target"`
+	Sequence *int                       `json:"sequence,omitempty"`
+	Status   int                        `json:"status,omitempty"`
+	Delay    string                     `json:"delay,omitempty"`
+	Error    string                     `json:"error,omitempty"`
+	Set      map[string]json.RawMessage `json:"set,omitempty"`
+}
+
+type OverridePlan struct {
+	Target   string          `json:"target"`
+	Sequence *int            `json:"sequence,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
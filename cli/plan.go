@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The debug config is sent as an inline plan, or references a plan stored in the runtime
+	inlinePlanPrefix = "v1:"
+	storedPlanPrefix = "plan:"
+	planVersion      = 1
+)
+
+// replayPlan adds the mapping to the plan, mapped observations like serviceC:HitCounter=pass are passed through
+func replayPlan(mapping map[string]string, plan ReplayPlan) ReplayPlan {
+	plan.Version = planVersion
+	plan.Mapping = make(map[string]string, len(mapping))
+	for name, host := range mapping {
+		if strings.Contains(name, ":") && host == "pass" {
+			plan.Pass = append(plan.Pass, name)
+		} else {
+			plan.Mapping[name] = host
+		}
+	}
+	return plan
+}
+
+// debugConfig is the inline plan of the replay
+func debugConfig(mapping map[string]string, plan ReplayPlan) string {
+	data, _ := json.Marshal(replayPlan(mapping, plan))
+	return inlinePlanPrefix + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// callsOut tells if the replayed services send calls out of the replay, to mapped hosts or live
+func callsOut(plan ReplayPlan) bool {
+	return len(plan.Mapping) > 0 || (plan.Missing != nil && plan.Missing.Policy == "live")
+}
+
+// replayConfig is the debug config of the replay, a stored plan also provides the service mapping.
+// When the runtime requires tokens a plan calling out is stored first, the replayed services only
+// call out with their ingest tokens when the plan was stored by a read token.
+func replayConfig(input *Input) (string, error) {
+	if input.PlanID == "" {
+		plan := replayPlan(input.Mapping, input.Plan)
+		if os.Getenv("REPLAY_TOKEN") == "" || !callsOut(plan) {
+			return debugConfig(input.Mapping, input.Plan), nil
+		}
+		id, err := storePlan(plan)
+		if err != nil {
+			return "", err
+		}
+		return storedPlanPrefix + id, nil
+	}
+
+	plan, err := getPlan(input.PlanID)
+	if err != nil {
+		return "", err
+	}
+	for name, host := range plan.Mapping {
+		input.Mapping[strings.ToLower(name)] = host
+	}
+	return storedPlanPrefix + input.PlanID, nil
+}
+
+func missingPlan(plan *MissingPlan) *MissingPlan {
+	if plan == nil {
+		return &MissingPlan{}
+	}
+	return plan
+}
+
+// faultPlan reads <action>@<target>=<value>, like status@serviceB=503 or set.items.0.price@serviceB=0,
+// the target is narrowed to one call or value with #<sequence>
+func faultPlan(value string) (FaultPlan, error) {
+	directive, v, ok := strings.Cut(value, "=")
+	action, target, found := strings.Cut(directive, "@")
+	if !ok || !found || action == "" || target == "" {
+		return FaultPlan{}, fmt.Errorf("Invalid fault %s, expected <action>@<target>=<value>", value)
+	}
+
+	fault := FaultPlan{Target: target}
+	if name, sequence, found := strings.Cut(target, "#"); found {
+		seq, err := strconv.Atoi(sequence)
+		if err != nil || seq < 0 {
+			return FaultPlan{}, fmt.Errorf("Invalid sequence of %s", value)
+		}
+		fault.Target, fault.Sequence = name, &seq
+	}
+
+	switch {
+	case action == "status":
+		status, err := strconv.Atoi(v)
+		if err != nil || status < 100 || status > 999 {
+			return FaultPlan{}, fmt.Errorf("Invalid status of %s", value)
+		}
+		fault.Status = status
+	case action == "delay":
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			return FaultPlan{}, fmt.Errorf("Invalid delay of %s", value)
+		}
+		fault.Delay = v
+	case action == "error":
+		fault.Error = v
+	case strings.HasPrefix(action, "set.") && len(action) > len("set."):
+		fault.Set = map[string]json.RawMessage{strings.TrimPrefix(action, "set."): jsonValue([]byte(v))}
+	default:
+		return FaultPlan{}, fmt.Errorf("Unknown fault %s, expected status, delay, error or set.<path>", action)
+	}
+	return fault, nil
+}
+
+// overridePlan reads <service>:<observation>=<value> replacing every observed value, or
+// <service>:<observation>[<sequence>]=<value> for one of them. The value is JSON, or @<file> to read it from a file.
+func overridePlan(value string) (OverridePlan, error) {
+	target, v, ok := strings.Cut(value, "=")
+	override := OverridePlan{Target: target}
+	if name, sequence, found := strings.Cut(target, "["); found {
+		seq, err := strconv.Atoi(strings.TrimSuffix(sequence, "]"))
+		if err != nil || seq < 0 || !strings.HasSuffix(sequence, "]") {
+			return OverridePlan{}, fmt.Errorf("Invalid sequence of %s", value)
+		}
+		override.Target, override.Sequence = name, &seq
+	}
+	if service, observation, found := strings.Cut(override.Target, ":"); !ok || !found || service == "" || observation == "" {
+		return OverridePlan{}, fmt.Errorf("Invalid override %s, expected <service>:<observation>=<value>", value)
+	}
+
+	data := []byte(v)
+	if file, found := strings.CutPrefix(v, "@"); found {
+		var err error
+		if data, err = os.ReadFile(file); err != nil {
+			return OverridePlan{}, err
+		}
+	}
+	override.Value = jsonValue(data)
+	return override, nil
+}
+
+// jsonValue takes anything that isn't JSON as a string
+func jsonValue(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return bytes.TrimSpace(data)
+	}
+	retval, _ := json.Marshal(string(data))
+	return retval
+}
+
+func readPlan(file string) (ReplayPlan, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return ReplayPlan{}, err
+	}
+
+	plan := ReplayPlan{}
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return ReplayPlan{}, fmt.Errorf("Invalid plan %s: %w", file, err)
+	}
+	if plan.Version != planVersion {
+		return ReplayPlan{}, fmt.Errorf("Unsupported plan version %d of %s", plan.Version, file)
+	}
+	return plan, nil
+}
+
+// savePlan stores the plan of the file in the runtime and returns its id
+func savePlan(file string) (string, error) {
+	plan, err := readPlan(file)
+	if err != nil {
+		return "", err
+	}
+	id, err := storePlan(plan)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", file, err)
+	}
+	return id, nil
+}
+
+func storePlan(plan ReplayPlan) (string, error) {
+	data, err := json.Marshal(plan)
+	if err != nil {
+		return "", err
+	}
+
+	req, err := newRuntimeRequest(http.MethodPost, planPath, bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("Unable to save plan, status code: %d", resp.StatusCode)
+	}
+
+	created := map[string]string{}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	return created["id"], nil
+}
+
+func getPlan(id string) (ReplayPlan, error) {
+	req, err := newRuntimeRequest(http.MethodGet, planPath+"?id="+url.QueryEscape(id), nil)
+	if err != nil {
+		return ReplayPlan{}, err
+	}
+
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return ReplayPlan{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ReplayPlan{}, fmt.Errorf("Couldn't find plan %s, a runtime with the memory store loses its plans on restart", id)
+	case http.StatusGone:
+		return ReplayPlan{}, fmt.Errorf("Plan %s was evicted, the runtime keeps the last 1000 plans, save it again", id)
+	default:
+		return ReplayPlan{}, fmt.Errorf("Unable to get plan %s, status code: %d", id, resp.StatusCode)
+	}
+
+	plan := ReplayPlan{}
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return ReplayPlan{}, err
+	}
+	return plan, nil
+}
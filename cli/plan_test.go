@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugConfig(t *testing.T) {
+	fault, err := faultPlan("set.items.0.name@serviceB#2=renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	override, err := overridePlan("serviceC:HitCounter[1]=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := ReplayPlan{Faults: []FaultPlan{fault}, Overrides: []OverridePlan{override}}
+
+	config := debugConfig(map[string]string{"servicea": "proxy.local/?a=1|b", "servicec:hitcounter": "pass"}, plan)
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(config, inlinePlanPrefix))
+	if err != nil || strings.Contains(config, "=") {
+		t.Fatalf("Want %v Actual %v %v\n", "inline plan", config, err)
+	}
+
+	act := ReplayPlan{}
+	if err := json.Unmarshal(data, &act); err != nil {
+		t.Fatal(err)
+	}
+	if act.Version != planVersion || act.Mapping["servicea"] != "proxy.local/?a=1|b" || len(act.Pass) != 1 || act.Pass[0] != "servicec:hitcounter" {
+		t.Errorf("Want %v Actual %v\n", "mapping and pass", act)
+	}
+	if f := act.Faults[0]; f.Target != "serviceB" || *f.Sequence != 2 || string(f.Set["items.0.name"]) != `"renamed"` {
+		t.Errorf("Want %v Actual %v\n", "fault of sequence 2", f)
+	}
+	if o := act.Overrides[0]; o.Target != "serviceC:HitCounter" || *o.Sequence != 1 || string(o.Value) != "0" {
+		t.Errorf("Want %v Actual %v\n", "override of sequence 1", o)
+	}
+
+	for _, invalid := range []string{"status@serviceB=fast", "status@serviceB#x=503", "drop@serviceB=1", "serviceB=503"} {
+		if _, err := faultPlan(invalid); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+	for _, invalid := range []string{"serviceC=0", "serviceC:HitCounter[x]=0", "serviceC:HitCounter"} {
+		if _, err := overridePlan(invalid); err == nil {
+			t.Errorf("Want %v Actual %v\n", "error", invalid)
+		}
+	}
+}
+
+func TestPlanIDOptions(t *testing.T) {
+	defer func(args []string) { os.Args = args }(os.Args)
+
+	os.Args = []string{"dbg", "replay", "rc", "--plan-id", "p1", "--record"}
+	if input, err := parseInput(); err != nil || input.PlanID != "p1" {
+		t.Errorf("Want %v Actual %v %v\n", "p1", input.PlanID, err)
+	}
+
+	// Every option configuring the replay is refused by name, wherever it is given
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--stub-body", "gone", "--plan-id", "p1"}, "--stub-body"},
+		{[]string{"--plan-id", "p1", "--fail-on-divergence", "--fault", "status@serviceB=503"}, "--fail-on-divergence, --fault"},
+		{[]string{"--plan-id", "p1", "--on-missing", "stub", "--stub", "404"}, "--on-missing, --stub"},
+		{[]string{"--plan-id", "p1", "--latency", "0", "--override", "serviceC:HitCounter=0"}, "--latency, --override"},
+		{[]string{"--plan-id", "p1", "--map"}, "--map"},
+	} {
+		os.Args = append([]string{"dbg", "replay", "rc"}, c.args...)
+		if _, err := parseInput(); err == nil || !strings.HasSuffix(err.Error(), "combined with "+c.want) {
+			t.Errorf("Want %v Actual %v\n", c.want, err)
+		}
+	}
+}
+
+func TestReplayOptions(t *testing.T) {
+	defer func(args []string) { os.Args = args }(os.Args)
+
+	// The mapping ends at the next option
+	os.Args = []string{"dbg", "replay", "rc", "--map", "serviceA=localhost:3000", "serviceC:HitCounter=pass", "--record"}
+	input, err := parseInput()
+	if err != nil || !input.Record || len(input.Mapping) != 2 || input.Mapping["servicec:hitcounter"] != "pass" {
+		t.Errorf("Want %v Actual %v %v\n", "mapping and record", input, err)
+	}
+
+	os.Args = []string{"dbg", "replay", "rc", "--map", "serviceA=localhost:3000", "record"}
+	if _, err := parseInput(); err == nil || !strings.Contains(err.Error(), "record") {
+		t.Errorf("Want %v Actual %v\n", "invalid mapping", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "plan.json")
+	os.WriteFile(file, []byte(`{"version": 1, "mapping": {"serviceB": "localhost:3001"}, "divergence": "fail"}`), 0o600)
+
+	// Options after the plan add to it, a mapping is kept wherever it is given
+	os.Args = []string{"dbg", "replay", "rc", "--map", "serviceA=localhost:3000", "--plan", file, "--fault", "status@serviceB=503"}
+	input, err = parseInput()
+	if err != nil || len(input.Mapping) != 2 || input.Plan.Divergence != "fail" || len(input.Plan.Faults) != 1 {
+		t.Errorf("Want %v Actual %v %v\n", "plan with fault", input, err)
+	}
+
+	for _, args := range [][]string{
+		{"--fault", "status@serviceB=503", "--plan", file},
+		{"--fail-on-divergence", "--map", "serviceA=localhost:3000", "--plan", file},
+		{"--plan", file, "--plan", file},
+	} {
+		os.Args = append([]string{"dbg", "replay", "rc"}, args...)
+		if _, err := parseInput(); err == nil || !strings.HasPrefix(err.Error(), "--plan replaces") {
+			t.Errorf("Want %v Actual %v %v\n", "--plan replaces", args, err)
+		}
+	}
+}
+
+func TestReplayConfigStoresPlan(t *testing.T) {
+	var stored ReplayPlan
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer cli" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&stored)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id": "p1"}`))
+	}))
+	defer runtime.Close()
+	t.Setenv("REPLAY_RUNTIME", runtime.URL)
+
+	// Without a token the plan is sent inline
+	input := Input{Mapping: map[string]string{"servicea": "localhost:3000"}}
+	if config, err := replayConfig(&input); err != nil || !strings.HasPrefix(config, inlinePlanPrefix) {
+		t.Errorf("Want %v Actual %v %v\n", "inline plan", config, err)
+	}
+
+	// The services replaying with ingest tokens only call out with a stored plan
+	t.Setenv("REPLAY_TOKEN", "cli")
+	if config, err := replayConfig(&input); err != nil || config != storedPlanPrefix+"p1" || stored.Mapping["servicea"] != "localhost:3000" {
+		t.Errorf("Want %v Actual %v %v %v\n", "stored plan", config, stored, err)
+	}
+
+	input = Input{Mapping: map[string]string{"servicec:hitcounter": "pass"}}
+	if config, err := replayConfig(&input); err != nil || !strings.HasPrefix(config, inlinePlanPrefix) {
+		t.Errorf("Want %v Actual %v %v\n", "inline plan", config, err)
+	}
+}
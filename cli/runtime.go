@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// The runtime is configured through the environment
+//
+//	REPLAY_RUNTIME          base URL of the runtime, http://localhost:8080 by default
+//	REPLAY_TOKEN            read token of the runtime
+//	REPLAY_CERT, REPLAY_KEY client certificate when the runtime requires mutual TLS
+//	REPLAY_CA               CA of the runtime certificate
+const defaultRuntimeHost = "http://localhost:8080"
+
+var runtimeClient = newRuntimeClient()
+
+func runtimeHost() string {
+	if host := os.Getenv("REPLAY_RUNTIME"); host != "" {
+		return strings.TrimSuffix(host, "/")
+	}
+	return defaultRuntimeHost
+}
+
+func newRuntimeClient() *http.Client {
+	cert, key, ca := os.Getenv("REPLAY_CERT"), os.Getenv("REPLAY_KEY"), os.Getenv("REPLAY_CA")
+	if cert == "" && ca == "" {
+		return http.DefaultClient
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cert != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			panic(err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	}
+	if ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			panic(err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			panic(fmt.Errorf("%s: no certificates found", ca))
+		}
+		config.RootCAs = pool
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}
+}
+
+// newRuntimeRequest creates an authorized request for a path of the runtime
+func newRuntimeRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, runtimeHost()+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv("REPLAY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Filters of the search action, passed on to the runtime as query parameters
+var searchFilters = map[string]bool{
+	"service":     true,
+	"route":       true,
+	"method":      true,
+	"status":      true,
+	"from":        true,
+	"to":          true,
+	"min-latency": true,
+	"max-latency": true,
+	"text":        true,
+	"limit":       true,
+	"replay-of":   true,
+}
+
+// parseSearchFilters reads --name value pairs, --since 1h is a shorthand for --from one hour ago
+func parseSearchFilters(args []string) (url.Values, error) {
+	filters := url.Values{}
+
+	for len(args) > 0 {
+		name, ok := strings.CutPrefix(args[0], "--")
+		if !ok || len(args) < 2 {
+			return nil, fmt.Errorf("Expected --filter value, got %s", args[0])
+		}
+		value := args[1]
+		args = args[2:]
+
+		switch {
+		case name == "since":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid since %s: %w", value, err)
+			}
+			filters.Set("from", time.Now().Add(-d).UTC().Format(time.RFC3339))
+		case searchFilters[name]:
+			filters.Set(strings.ReplaceAll(name, "-", "_"), value)
+		default:
+			return nil, fmt.Errorf("Unknown filter --%s", name)
+		}
+	}
+	return filters, nil
+}
+
+func searchRequests(filters url.Values) ([]ContextInfo, error) {
+	req, err := newRuntimeRequest(http.MethodGet, searchPath+filters.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return nil, fmt.Errorf("Not authorized to search, check REPLAY_TOKEN")
+	case http.StatusBadRequest:
+		return nil, fmt.Errorf("Invalid search filters")
+	default:
+		return nil, fmt.Errorf("Search failed, status code: %d", resp.StatusCode)
+	}
+
+	results := make([]ContextInfo, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func printSearchResults(results []ContextInfo) {
+	if len(results) == 0 {
+		fmt.Println("No matching requests")
+		return
+	}
+
+	for _, r := range results {
+		service := r.Service
+		if service == "" {
+			service = "[Incomplete]"
+		}
+		replay := ""
+		if r.ReplayOf != "" {
+			replay = "  replay of " + r.ReplayOf
+		}
+		fmt.Printf("%s  %s  %s %s %s  (%d) %dms  %d records%s\n",
+			r.Time.Local().Format(time.DateTime), r.RequestContext, service, r.Method, r.Uri, r.StatusCode, r.Duration, r.Records, replay)
+	}
+}
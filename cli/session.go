@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+const timelineWidth = 56
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+func getSession(id string) (ReplaySession, error) {
+	req, err := newRuntimeRequest(http.MethodGet, sessionPath+url.QueryEscape(id), nil)
+	if err != nil {
+		return ReplaySession{}, err
+	}
+
+	resp, err := runtimeClient.Do(req)
+	if err != nil {
+		return ReplaySession{}, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return ReplaySession{}, fmt.Errorf("Coudn't find replay session %s, sessions are kept in memory by the runtime", id)
+	default:
+		return ReplaySession{}, fmt.Errorf("Unable to get replay session %s, status code: %d", id, resp.StatusCode)
+	}
+
+	session := ReplaySession{}
+	if err := json.NewDecoder(resp.Body).Decode(&session); err != nil {
+		return ReplaySession{}, err
+	}
+	return session, nil
+}
+
+// timelineRow pairs a recorded step with what happened to it during replay, either side can be empty
+type timelineRow struct {
+	Original string
+	Replay   string
+}
+
+// executionTimeline holds the steps of one replayed execution
+type executionTimeline struct {
+	Title string
+	Rows  []timelineRow
+}
+
+// sessionTimeline lines the events of a session up with the recorded calls of the executions they belong to.
+// Calls are matched by URI and scoped sequence, like the runtime matches them with the capture.
+func sessionTimeline(session ReplaySession, request Request) []executionTimeline {
+	byExecution := make(map[string]Request)
+	indexExecutions(request, byExecution)
+
+	order := make([]string, 0)
+	events := make(map[string][]SessionEvent)
+	for _, e := range session.Events {
+		if _, ok := events[e.ExecutionContext]; !ok {
+			order = append(order, e.ExecutionContext)
+		}
+		events[e.ExecutionContext] = append(events[e.ExecutionContext], e)
+	}
+
+	retval := make([]executionTimeline, 0, len(order))
+	for _, ec := range order {
+		execution, ok := byExecution[ec]
+		timeline := executionTimeline{Title: "Unknown execution " + ec}
+		if ok {
+			timeline.Title = fmt.Sprintf("%s %s %s", execution.In.ServiceName, execution.In.Method, execution.In.Uri)
+		}
+
+		used := make([]bool, len(events[ec]))
+		match := func(matches func(SessionEvent) bool) string {
+			for i, e := range events[ec] {
+				if !used[i] && matches(e) {
+					used[i] = true
+					return formatEvent(e, false)
+				}
+			}
+			return ""
+		}
+
+		if ok {
+			timeline.Rows = append(timeline.Rows, timelineRow{
+				Original: fmt.Sprintf("%d observations", len(execution.Observations)),
+				Replay:   match(func(e SessionEvent) bool { return e.Kind == "observations" }),
+			})
+		}
+		for _, dep := range execution.Dependencies {
+			timeline.Rows = append(timeline.Rows, timelineRow{
+				Original: fmt.Sprintf("%s %s [%d] (%d) %dms", dep.In.Method, dep.In.Uri, dep.In.ScopedSequence, dep.Out.StatusCode, dep.Out.Duration),
+				Replay: match(func(e SessionEvent) bool {
+					return e.Kind != "observations" && e.Uri == dep.In.Uri && e.ScopedSequence == dep.In.ScopedSequence
+				}),
+			})
+		}
+
+		// Whatever is left wasn't part of the capture
+		for i, e := range events[ec] {
+			if !used[i] {
+				timeline.Rows = append(timeline.Rows, timelineRow{Replay: formatEvent(e, true)})
+			}
+		}
+		retval = append(retval, timeline)
+	}
+	return retval
+}
+
+func indexExecutions(request Request, byExecution map[string]Request) {
+	if request.In.ExecutionContext != "" {
+		byExecution[request.In.ExecutionContext] = request
+	}
+	for _, dep := range request.Dependencies {
+		indexExecutions(dep.Reference, byExecution)
+	}
+}
+
+func formatEvent(e SessionEvent, withCall bool) string {
+	text := e.Kind
+	if withCall && e.Uri != "" {
+		text += fmt.Sprintf(" %s %s [%d]", e.Method, e.Uri, e.ScopedSequence)
+	}
+	if e.StatusCode != 0 {
+		text += fmt.Sprintf(" (%d)", e.StatusCode)
+	}
+	if e.Kind == "forward" {
+		text += fmt.Sprintf(" %dms", e.Duration)
+	}
+	if e.Divergence != "" {
+		text += " diverged: " + e.Divergence
+	}
+	if e.Detail != "" {
+		text += " " + e.Detail
+	}
+	return text
+}
+
+func printSession(session ReplaySession, request Request) {
+	fmt.Printf("Replay session %s of %s, started %s\n", session.ID, session.RequestContext, session.Started.Local().Format("2006-01-02 15:04:05"))
+
+	for _, timeline := range sessionTimeline(session, request) {
+		fmt.Printf("\n%s\n", timeline.Title)
+		fmt.Printf("    %-*s %s\n", timelineWidth, "ORIGINAL", "REPLAY")
+		for _, row := range timeline.Rows {
+			original := row.Original
+			if original == "" {
+				original = "-"
+			}
+			if len(original) > timelineWidth {
+				original = original[:timelineWidth-3] + "..."
+			}
+			replay := row.Replay
+			if replay == "" {
+				replay = "-"
+			}
+			fmt.Printf("    %-*s %s\n", timelineWidth, original, replay)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSessionTimeline(t *testing.T) {
+	request := Request{
+		In: Record{ExecutionContext: "e1", ServiceName: "ServiceA", Method: "GET", Uri: "/a"},
+		Observations: []Record{
+			{ObservationName: "Now"},
+		},
+		Dependencies: []Dependency{
+			{
+				In:        Record{Method: "GET", Uri: "http://b/count", ScopedSequence: 0},
+				Out:       Record{StatusCode: 200, Duration: 12},
+				Reference: Request{In: Record{ExecutionContext: "e2", ServiceName: "ServiceB", Method: "GET", Uri: "/count"}},
+			},
+			{
+				In:  Record{Method: "GET", Uri: "http://b/count", ScopedSequence: 1},
+				Out: Record{StatusCode: 200, Duration: 3},
+			},
+		},
+	}
+
+	session := ReplaySession{Events: []SessionEvent{
+		{Kind: "observations", ExecutionContext: "e1", Detail: "1 values served"},
+		{Kind: "forward", ExecutionContext: "e1", Method: "GET", Uri: "http://b/count", ScopedSequence: 0, StatusCode: 500, Duration: 7},
+		{Kind: "snapshot", ExecutionContext: "e1", Method: "POST", Uri: "http://c/new", StatusCode: 404, Divergence: "call"},
+		{Kind: "observations", ExecutionContext: "e2", Detail: "0 values served"},
+	}}
+
+	want := []executionTimeline{
+		{Title: "ServiceA GET /a", Rows: []timelineRow{
+			{"1 observations", "observations 1 values served"},
+			{"GET http://b/count [0] (200) 12ms", "forward (500) 7ms"},
+			{"GET http://b/count [1] (200) 3ms", ""},
+			{"", "snapshot POST http://c/new [0] (404) diverged: call"},
+		}},
+		{Title: "ServiceB GET /count", Rows: []timelineRow{
+			{"0 observations", "observations 0 values served"},
+		}},
+	}
+
+	act := sessionTimeline(session, request)
+	if !reflect.DeepEqual(want, act) {
+		t.Errorf("Want %v Actual %v\n", want, act)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type ShowOptions struct {
+	Verbose bool   // Method, URI, durations and observed values
+	Headers bool   //
+	Bodies  bool   //
+	JSON    bool   // The request tree as JSON instead of text
+	Depth   int    // Levels of dependencies shown, -1 shows all of them
+	Service string // Only the requests handled by this service, with their dependencies
+}
+
+func showRequest(request Request, options ShowOptions) error {
+	roots := []Request{request}
+	if options.Service != "" {
+		roots = findService(request, options.Service, nil)
+		if len(roots) == 0 {
+			fmt.Printf("No request handled by %s\n", options.Service)
+			return nil
+		}
+	}
+
+	if options.JSON {
+		trimmed := make([]Request, len(roots))
+		for i := range roots {
+			trimmed[i] = trimRequest(roots[i], options.Depth)
+		}
+
+		var value any = trimmed
+		if options.Service == "" {
+			value = trimmed[0]
+		}
+		data, err := json.MarshalIndent(value, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	for _, root := range roots {
+		printRequest(root, root.In, root.Out, 0, options)
+	}
+	return nil
+}
+
+// findService returns the requests handled by the service, outermost first
+func findService(request Request, service string, found []Request) []Request {
+	if strings.EqualFold(request.In.ServiceName, service) {
+		return append(found, request)
+	}
+	for _, dep := range request.Dependencies {
+		found = findService(dep.Reference, service, found)
+	}
+	return found
+}
+
+// trimRequest drops the requests of dependencies deeper than depth, keeping the calls themselves
+func trimRequest(request Request, depth int) Request {
+	deps := make([]Dependency, len(request.Dependencies))
+	for i, dep := range request.Dependencies {
+		deps[i] = dep
+		if depth == 0 {
+			deps[i].Reference = Request{}
+		} else {
+			deps[i].Reference = trimRequest(dep.Reference, depth-1)
+		}
+	}
+	request.Dependencies = deps
+	return request
+}
+
+func getPreposition(level int) string {
+	return strings.Join(make([]string, level+1), "    ")
+}
+
+// printRequest prints a request of the tree, in and out are the records of the call that led to it
+func printRequest(request Request, in, out Record, level int, options ShowOptions) {
+	pre := getPreposition(level)
+	serviceName := request.Out.ServiceName
+	if serviceName == "" {
+		serviceName = "[External]"
+	}
+
+	status := strconv.Itoa(out.StatusCode)
+	if out.TransportError != "" {
+		// The call failed without a response
+		status = "error " + out.TransportError
+	}
+
+	if options.Verbose {
+		fmt.Printf("%s-> %s %s %s (%s) %dms\n", pre, serviceName, in.Method, in.Uri, status, out.Duration)
+	} else {
+		fmt.Printf("%s-> %s (%s)\n", pre, serviceName, status)
+	}
+
+	detailPre := getPreposition(level + 1)
+	if options.Headers {
+		printHeader(detailPre+"> ", in.Header)
+		printHeader(detailPre+"< ", out.Header)
+	}
+	if options.Bodies {
+		printBody(detailPre+"> ", in.Body)
+		printBody(detailPre+"< ", out.Body)
+	}
+
+	for i := range request.Observations {
+		ob := request.Observations[i]
+		if !options.Verbose {
+			fmt.Printf("%s-> Internal <%s[%d]>\n", detailPre, ob.ObservationName, ob.ScopedSequence)
+			continue
+		}
+		fmt.Printf("%s-> Internal <%s[%d]> = %s\n", detailPre, ob.ObservationName, ob.ScopedSequence, decodeObservation(ob.Body))
+		if len(ob.ObservationError) > 0 {
+			fmt.Printf("%s   error: %s\n", detailPre, string(ob.ObservationError))
+		}
+	}
+
+	if options.Depth >= 0 && level >= options.Depth {
+		if len(request.Dependencies) > 0 {
+			fmt.Printf("%s... %d dependencies\n", detailPre, len(request.Dependencies))
+		}
+		return
+	}
+	for i := range request.Dependencies {
+		dep := request.Dependencies[i]
+		printRequest(dep.Reference, dep.In, dep.Out, level+1, options)
+	}
+}
+
+func printHeader(pre string, header map[string][]string) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, v := range header[name] {
+			fmt.Printf("%s%s: %s\n", pre, name, v)
+		}
+	}
+}
+
+func printBody(pre string, body []byte) {
+	if len(body) == 0 {
+		return
+	}
+
+	text := string(body)
+	indented := &bytes.Buffer{}
+	if json.Indent(indented, body, "", "  ") == nil {
+		text = indented.String()
+	} else if !utf8.Valid(body) {
+		text = fmt.Sprintf("<%d bytes binary>", len(body))
+	}
+
+	for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		fmt.Printf("%s%s\n", pre, line)
+	}
+}
+
+// decodeObservation guesses how an observed value was encoded. The SDK writes strings as they are,
+// numbers and bools little endian and everything else with gob, which can't be decoded without the type.
+func decodeObservation(data []byte) string {
+	if len(data) == 0 {
+		return "<empty>"
+	}
+
+	if utf8.Valid(data) && strings.IndexFunc(string(data), func(r rune) bool { return !unicode.IsPrint(r) && !unicode.IsSpace(r) }) == -1 {
+		return strconv.Quote(string(data))
+	}
+
+	switch len(data) {
+	case 1:
+		return strconv.Itoa(int(int8(data[0])))
+	case 2:
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(data))))
+	case 4:
+		n := int32(binary.LittleEndian.Uint32(data))
+		if f := math.Float32frombits(uint32(n)); n > 1<<24 || n < -(1<<24) {
+			if !math.IsNaN(float64(f)) && !math.IsInf(float64(f), 0) {
+				return strconv.FormatFloat(float64(f), 'g', -1, 32)
+			}
+		}
+		return strconv.Itoa(int(n))
+	case 8:
+		n := int64(binary.LittleEndian.Uint64(data))
+		if f := math.Float64frombits(uint64(n)); n > 1<<53 || n < -(1<<53) {
+			if !math.IsNaN(f) && !math.IsInf(f, 0) {
+				return strconv.FormatFloat(f, 'g', -1, 64)
+			}
+		}
+		return strconv.FormatInt(n, 10)
+	}
+
+	if len(data) > 64 {
+		return fmt.Sprintf("<%d bytes> %s...", len(data), hex.EncodeToString(data[:64]))
+	}
+	return fmt.Sprintf("<%d bytes> %s", len(data), hex.EncodeToString(data))
+}
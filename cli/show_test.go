@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureOutput returns what f prints
+func captureOutput(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func TestDecodeObservation(t *testing.T) {
+	float32Bytes := binary.LittleEndian.AppendUint32(nil, math.Float32bits(1.5))
+	float64Bytes := binary.LittleEndian.AppendUint64(nil, math.Float64bits(2.5))
+
+	cases := []struct {
+		data []byte
+		want string
+	}{
+		{nil, "<empty>"},
+		{[]byte("hello world\n"), `"hello world\n"`},
+		{[]byte{0xff}, "-1"},
+		{binary.LittleEndian.AppendUint16(nil, 300), "300"},
+		{binary.LittleEndian.AppendUint32(nil, 42), "42"},
+		{float32Bytes, "1.5"},
+		{binary.LittleEndian.AppendUint64(nil, uint64(math.MaxUint64-4)), "-5"},
+		{float64Bytes, "2.5"},
+		{[]byte{0, 1, 2}, "<3 bytes> 000102"},
+		{bytes.Repeat([]byte{0}, 70), "<70 bytes> " + strings.Repeat("00", 64) + "..."},
+	}
+	for _, c := range cases {
+		if act := decodeObservation(c.data); act != c.want {
+			t.Errorf("Want %v Actual %v\n", c.want, act)
+		}
+	}
+}
+
+func showTree() Request {
+	return Request{
+		In:           Record{ServiceName: "ServiceA", Method: "GET", Uri: "/boost"},
+		Out:          Record{ServiceName: "ServiceA", StatusCode: 200, Duration: 12},
+		Observations: []Record{{ObservationName: "Name", Body: []byte("a")}},
+		Dependencies: []Dependency{
+			{
+				In:  Record{Method: "POST", Uri: "http://b/count"},
+				Out: Record{StatusCode: 201, Duration: 5},
+				Reference: Request{
+					In:  Record{ServiceName: "ServiceB", Method: "POST", Uri: "/count"},
+					Out: Record{ServiceName: "ServiceB", StatusCode: 201},
+					Dependencies: []Dependency{
+						{In: Record{Method: "GET", Uri: "http://c/stock"}, Out: Record{TransportError: "refused: connection refused"}},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestShowRequest(t *testing.T) {
+	cases := []struct {
+		options ShowOptions
+		want    string
+	}{
+		{ShowOptions{Depth: -1}, "-> ServiceA (200)\n" +
+			"    -> Internal <Name[0]>\n" +
+			"    -> ServiceB (201)\n" +
+			"        -> [External] (error refused: connection refused)\n"},
+		{ShowOptions{Depth: 0, Verbose: true}, "-> ServiceA GET /boost (200) 12ms\n" +
+			"    -> Internal <Name[0]> = \"a\"\n" +
+			"    ... 1 dependencies\n"},
+		{ShowOptions{Depth: -1, Service: "serviceb"}, "-> ServiceB (201)\n" +
+			"    -> [External] (error refused: connection refused)\n"},
+		{ShowOptions{Depth: -1, Service: "ServiceD"}, "No request handled by ServiceD\n"},
+	}
+	for _, c := range cases {
+		act := captureOutput(t, func() {
+			if err := showRequest(showTree(), c.options); err != nil {
+				t.Error(err)
+			}
+		})
+		if act != c.want {
+			t.Errorf("Want %v Actual %v\n", c.want, act)
+		}
+	}
+}
+
+func TestShowRequestJSON(t *testing.T) {
+	// The calls beyond the depth are kept without their requests
+	var request Request
+	output := captureOutput(t, func() { showRequest(showTree(), ShowOptions{JSON: true, Depth: 0}) })
+	if err := json.Unmarshal([]byte(output), &request); err != nil {
+		t.Fatal(err)
+	}
+	if dep := request.Dependencies[0]; dep.In.Uri != "http://b/count" || dep.Reference.In.ServiceName != "" {
+		t.Errorf("Want %v Actual %+v\n", "call without its request", dep)
+	}
+
+	// The requests handled by the service are a list
+	var requests []Request
+	output = captureOutput(t, func() { showRequest(showTree(), ShowOptions{JSON: true, Depth: -1, Service: "ServiceB"}) })
+	if err := json.Unmarshal([]byte(output), &requests); err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 1 || requests[0].In.ServiceName != "ServiceB" || requests[0].Dependencies[0].Out.TransportError == "" {
+		t.Errorf("Want %v Actual %+v\n", "ServiceB", requests)
+	}
+}
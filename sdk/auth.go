@@ -0,0 +1,36 @@
+package sdk
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// Services in debug mode send their own Authorization header to dependencies,
+// so the token of the service is sent to the runtime in a separate header
+const RuntimeAuthorizationHeader = "X-Runtime-Authorization"
+
+type AuthConfig struct {
+	Token string      // Token of the service for the runtime
+	TLS   *tls.Config // Client certificates and CAs when the runtime requires mutual TLS
+}
+
+var (
+	runtimeToken     string
+	runtimeTransport http.RoundTripper = http.DefaultTransport
+)
+
+func newRuntimeTransport(config *tls.Config) http.RoundTripper {
+	if config == nil {
+		return http.DefaultTransport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return transport
+}
+
+// authorize adds the token of the service to a request for the runtime
+func authorize(req *http.Request) {
+	if runtimeToken != "" {
+		req.Header.Set(RuntimeAuthorizationHeader, "Bearer "+runtimeToken)
+	}
+}
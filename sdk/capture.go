@@ -0,0 +1,53 @@
+package sdk
+
+import (
+	"math/rand/v2"
+	"net/http"
+)
+
+const (
+	CaptureHeader      = "X-Capture"
+	ForceCaptureHeader = "X-Force-Capture"
+
+	CaptureEnabled  = "1"
+	CaptureDisabled = "0"
+)
+
+// CaptureConfig decides which edge requests are recorded. The decision is made once by the
+// edge service and propagated downstream, so a request tree is either recorded by every service or by none.
+type CaptureConfig struct {
+	SampleRate  float64            // Fraction of edge requests recorded, between 0 and 1, every request when not set
+	Routes      map[string]float64 // Sample rate per pattern registered with HandleFunc, overrides SampleRate
+	ForceHeader string             // Edge requests carrying this header are always recorded, empty disables
+}
+
+// withDefaults records every request when no rate is set, a negative rate records only the routes and forced requests
+func (c CaptureConfig) withDefaults() CaptureConfig {
+	if c.SampleRate == 0 {
+		c.SampleRate = 1
+	}
+	return c
+}
+
+func (c CaptureConfig) Sample(r *http.Request) bool {
+	if c.ForceHeader != "" && r.Header.Get(c.ForceHeader) != "" {
+		return true
+	}
+
+	rate := c.SampleRate
+	if routeRate, ok := c.Routes[r.Pattern]; ok {
+		rate = routeRate
+	}
+
+	if rate >= 1 {
+		return true
+	}
+	return rate > 0 && rand.Float64() < rate
+}
+
+func captureHeaderValue(capture bool) string {
+	if capture {
+		return CaptureEnabled
+	}
+	return CaptureDisabled
+}
@@ -0,0 +1,91 @@
+package sdk
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCaptureSample(t *testing.T) {
+	config := CaptureConfig{
+		SampleRate:  -1,
+		Routes:      map[string]float64{"/always": 1},
+		ForceHeader: ForceCaptureHeader,
+	}
+
+	r := httptest.NewRequest("GET", "/boost", nil)
+	if config.Sample(r) {
+		t.Errorf("Want %v Actual %v\n", false, true)
+	}
+
+	r.Pattern = "/always"
+	if !config.Sample(r) {
+		t.Errorf("Want %v Actual %v\n", true, false)
+	}
+
+	r = httptest.NewRequest("GET", "/boost", nil)
+	r.Header.Set(ForceCaptureHeader, "1")
+	if !config.Sample(r) {
+		t.Errorf("Want %v Actual %v\n", true, false)
+	}
+}
+
+func TestCaptureDefaults(t *testing.T) {
+	// An unset rate records every request
+	r := httptest.NewRequest("GET", "/boost", nil)
+	if !(CaptureConfig{}).withDefaults().Sample(r) {
+		t.Errorf("Want %v Actual %v\n", true, false)
+	}
+	if config := (CaptureConfig{SampleRate: 0.1}).withDefaults(); config.SampleRate != 0.1 {
+		t.Errorf("Want %v Actual %v\n", 0.1, config.SampleRate)
+	}
+}
+
+func TestCapturePropagation(t *testing.T) {
+	captureConfig = CaptureConfig{SampleRate: -1}
+	defer func() { captureConfig = CaptureConfig{} }()
+
+	// Edge request decides
+	r := httptest.NewRequest("GET", "/boost", nil)
+	sc, err := NewServiceContext(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.Capture {
+		t.Errorf("Want %v Actual %v\n", false, true)
+	}
+
+	// Internal request follows the upstream decision
+	r = httptest.NewRequest("GET", "/boost", nil)
+	r.Header.Set(RequestContextHeader, "rc")
+	r.Header.Set(CauseContextHeader, "cc")
+	r.Header.Set(ExecutionContextHeader, "ec")
+	r.Header.Set(CaptureHeader, CaptureEnabled)
+	sc, err = NewServiceContext(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.Capture {
+		t.Errorf("Want %v Actual %v\n", true, false)
+	}
+}
+
+func TestReplayRecording(t *testing.T) {
+	logEnabled = true
+	defer func() { logEnabled = false }()
+
+	// Replays are only recorded when asked to, under their own request context
+	sc := &ServiceContext{RequestContext: "rc", Debug: true}
+	if sc.Recording() {
+		t.Errorf("Want %v Actual %v\n", false, true)
+	}
+
+	sc.ReplayContext = "rc.replay.s1"
+	if !sc.Recording() || sc.RecordContext() != "rc.replay.s1" {
+		t.Errorf("Want %v Actual %v %v\n", "rc.replay.s1", sc.Recording(), sc.RecordContext())
+	}
+
+	sc = &ServiceContext{RequestContext: "rc", Capture: true, ReplayContext: "rc.replay.s1"}
+	if !sc.Recording() || sc.RecordContext() != "rc" {
+		t.Errorf("Want %v Actual %v %v\n", "rc", sc.Recording(), sc.RecordContext())
+	}
+}
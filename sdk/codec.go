@@ -0,0 +1,87 @@
+package sdk
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// RecordStreamContentType is the binary format accepted by the runtime next to JSON. A stream
+// starts with the magic "RREC" and a version byte, followed by records each prefixed with their
+// length as an uvarint. Bodies are written as raw bytes instead of base64.
+const (
+	RecordStreamContentType = "application/x-record-stream"
+
+	recordStreamMagic   = "RREC"
+	recordStreamVersion = 1
+)
+
+type recordWriter struct {
+	w       io.Writer
+	started bool
+	frame   []byte
+}
+
+func newRecordWriter(w io.Writer) *recordWriter {
+	return &recordWriter{w: w}
+}
+
+func (rw *recordWriter) Write(rec *Record) error {
+	if !rw.started {
+		if _, err := rw.w.Write(append([]byte(recordStreamMagic), recordStreamVersion)); err != nil {
+			return err
+		}
+		rw.started = true
+	}
+
+	rw.frame = appendRecord(rw.frame[:0], rec)
+	prefix := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen64), uint64(len(rw.frame)))
+	if _, err := rw.w.Write(prefix); err != nil {
+		return err
+	}
+	_, err := rw.w.Write(rw.frame)
+	return err
+}
+
+func appendString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// Must stay in sync with the decoder of the runtime
+func appendRecord(b []byte, rec *Record) []byte {
+	for _, s := range []string{rec.RequestContext, rec.CauseContext, rec.ExecutionContext, rec.DependencyContext,
+		string(rec.RecordType), rec.Method, rec.ServiceName, rec.ObservationName, rec.Host, rec.Uri} {
+		b = appendString(b, s)
+	}
+
+	b = binary.AppendVarint(b, rec.Time.Unix())
+	b = binary.AppendUvarint(b, uint64(rec.Time.Nanosecond()))
+	for _, n := range []int64{rec.Duration, int64(rec.DepencencySequence), int64(rec.ScopedSequence),
+		int64(rec.ObservationSequence), int64(rec.StatusCode)} {
+		b = binary.AppendVarint(b, n)
+	}
+
+	b = binary.AppendUvarint(b, uint64(len(rec.Header)))
+	for name, vals := range rec.Header {
+		b = appendString(b, name)
+		b = binary.AppendUvarint(b, uint64(len(vals)))
+		for _, v := range vals {
+			b = appendString(b, v)
+		}
+	}
+
+	b = appendBytes(b, rec.Body)
+	b = appendBytes(b, rec.ObservationError)
+
+	b = binary.AppendUvarint(b, uint64(len(rec.Redacted)))
+	for _, path := range rec.Redacted {
+		b = appendString(b, path)
+	}
+	b = appendString(b, rec.TransportError)
+	return b
+}
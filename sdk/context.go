@@ -22,6 +22,8 @@ const (
 	DebugConfigHeader              = "X-Debug-Config"
 	DepencencySequenceHeader       = "X-Dependency-Sequence"
 	ScopedDependencySequenceHeader = "X-Scoped-Dependency-Sequence"
+	ReplaySessionHeader            = "X-Replay-Session"
+	ReplayContextHeader            = "X-Replay-Context"
 
 	DebugEnabled = "ENABLED"
 )
@@ -29,6 +31,7 @@ const (
 type ObservationData struct {
 	Body             []byte `json:"bd"`
 	ObservationError []byte `json:"oe"`
+	Override         bool   `json:"ov,omitempty"` // Body is a JSON value given for the replay instead of a recorded value
 }
 
 type Observations struct {
@@ -40,8 +43,11 @@ type ServiceContext struct {
 	CauseContext        string
 	ExecutionContext    string
 	Debug               bool
-	DebugConfig         string // ServiceName:Hostname|ServiceName:Hostname tells debug host how to route requests
+	Capture             bool   // Whether the request tree is recorded, decided by the edge service
+	DebugConfig         string // Replay plan, or ServiceName=Hostname|ServiceName=Hostname, tells debug host how to route requests
 	DebugHost           string
+	ReplaySession       string // Replay session the runtime logs this request's calls and observations into
+	ReplayContext       string // Request context a replay is recorded under, replays aren't recorded without it
 	depencencySequence  int
 	scopedSequenc       map[string]int
 	observationSequence int
@@ -49,6 +55,22 @@ type ServiceContext struct {
 	waiter              <-chan interface{}
 }
 
+// Recording tells if records of this request should be logged
+func (sc *ServiceContext) Recording() bool {
+	if sc.Debug {
+		return logEnabled && sc.ReplayContext != ""
+	}
+	return logEnabled && sc.Capture
+}
+
+// RecordContext is the request context records are logged under, a recorded replay gets its own
+func (sc *ServiceContext) RecordContext() string {
+	if sc.Debug && sc.ReplayContext != "" {
+		return sc.ReplayContext
+	}
+	return sc.RequestContext
+}
+
 func (sc *ServiceContext) NewExecutionID() string {
 	return uuid.NewString()
 }
@@ -111,7 +133,12 @@ func (sc *ServiceContext) LoadObservations() {
 			return
 		}
 		req.Header.Set(RequestContextHeader, sc.RequestContext)
+		req.Header.Set(ExecutionContextHeader, sc.ExecutionContext)
 		req.Header.Set(DebugConfigHeader, sc.DebugConfig)
+		if sc.ReplaySession != "" {
+			req.Header.Set(ReplaySessionHeader, sc.ReplaySession)
+		}
+		authorize(req)
 		resp, err := ObserverClient.Do(req)
 		if err != nil {
 			fmt.Printf("Error requesting observation data: %s\n", err.Error())
@@ -152,7 +179,10 @@ func NewServiceContext(r *http.Request) (*ServiceContext, error) {
 		CauseContext:        r.Header.Get(CauseContextHeader),
 		ExecutionContext:    r.Header.Get(ExecutionContextHeader),
 		DebugConfig:         r.Header.Get(DebugConfigHeader),
+		ReplaySession:       r.Header.Get(ReplaySessionHeader),
+		ReplayContext:       r.Header.Get(ReplayContextHeader),
 		Debug:               r.Header.Get(ServiceDebugHeader) == DebugEnabled,
+		Capture:             r.Header.Get(CaptureHeader) != CaptureDisabled,
 		depencencySequence:  0,
 		scopedSequenc:       map[string]int{},
 		observationSequence: 0,
@@ -168,6 +198,7 @@ func NewServiceContext(r *http.Request) (*ServiceContext, error) {
 		s.RequestContext = uuid.NewString()
 		s.CauseContext = s.RequestContext
 		s.ExecutionContext = uuid.NewString()
+		s.Capture = captureConfig.Sample(r)
 	}
 
 	if s.CauseContext == "" || s.ExecutionContext == "" {
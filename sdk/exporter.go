@@ -0,0 +1,521 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DropPolicy int
+
+const (
+	DropNewest DropPolicy = iota // Records logged while the queue is full are dropped
+	DropOldest                   // The oldest queued record is dropped to make room
+	Block                        // Logging waits for room in the queue, until the exporter stops
+)
+
+type Encoding int
+
+const (
+	JSONEncoding   Encoding = iota // Understood by every runtime
+	StreamEncoding                 // Binary record stream, smaller and decoded incrementally by the runtime
+)
+
+// ExporterConfig controls how records are shipped to the runtime, zero values fall back to the defaults
+type ExporterConfig struct {
+	QueueSize     int           // Records buffered before the drop policy applies
+	DropPolicy    DropPolicy    //
+	BatchSize     int           // Records sent in one request, a full batch is sent right away
+	FlushInterval time.Duration // Longest time a record waits for its batch to fill up
+	MaxRetries    int           // Attempts after the first one before a batch is spilled or dropped
+	RetryBackoff  time.Duration // Delay before the first retry, doubled on every retry with jitter
+	MaxBackoff    time.Duration //
+	Compress      bool          // gzip request bodies
+	Encoding      Encoding      //
+	SpillDir      string        // Batches the runtime couldn't take are written here and sent later, empty disables
+	MaxSpillBytes int64         // Oldest spilled batches are dropped beyond this size
+}
+
+func DefaultExporterConfig() ExporterConfig {
+	return ExporterConfig{
+		QueueSize:     10000,
+		DropPolicy:    DropNewest,
+		BatchSize:     500,
+		FlushInterval: 5 * time.Second,
+		MaxRetries:    3,
+		RetryBackoff:  500 * time.Millisecond,
+		MaxBackoff:    10 * time.Second,
+		Compress:      true,
+		Encoding:      JSONEncoding,
+		MaxSpillBytes: 256 << 20,
+	}
+}
+
+func (c ExporterConfig) withDefaults() ExporterConfig {
+	d := DefaultExporterConfig()
+	if c.QueueSize <= 0 {
+		c.QueueSize = d.QueueSize
+	}
+	if c.BatchSize <= 0 {
+		c.BatchSize = d.BatchSize
+	}
+	if c.FlushInterval <= 0 {
+		c.FlushInterval = d.FlushInterval
+	}
+	if c.MaxRetries < 0 {
+		c.MaxRetries = 0
+	}
+	if c.RetryBackoff <= 0 {
+		c.RetryBackoff = d.RetryBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = d.MaxBackoff
+	}
+	if c.MaxSpillBytes <= 0 {
+		c.MaxSpillBytes = d.MaxSpillBytes
+	}
+	return c
+}
+
+// ExporterMetrics counts records by what happened to them
+type ExporterMetrics struct {
+	Queued    uint64 // Accepted into the queue
+	Sent      uint64 // Accepted by the runtime
+	Dropped   uint64 // Dropped by the drop policy, the spill limit or once closed
+	Failed    uint64 // Rejected by the runtime or lost without a spill directory
+	Spilled   uint64 // Written to the spill directory
+	Retries   uint64 // Retried requests
+	QueueSize int    // Records currently waiting in the queue
+}
+
+type exporter struct {
+	config  ExporterConfig
+	postUrl string
+	client  *http.Client
+	queue   chan Record
+	healthy bool // Whether the last request reached the runtime, only used by the run loop
+	flushes chan chan struct{}
+	mu      sync.RWMutex // Held by Enqueue while it queues, Close waits for it to count what's left
+	closed  atomic.Bool
+	stop    context.CancelFunc
+	done    chan struct{}
+
+	queued, sent, dropped, failed, spilled, retries atomic.Uint64
+}
+
+var errRejected = errors.New("records rejected by the runtime")
+
+func newExporter(host string, config ExporterConfig) *exporter {
+	config = config.withDefaults()
+
+	if config.SpillDir != "" {
+		if err := os.MkdirAll(config.SpillDir, 0o755); err != nil {
+			fmt.Printf("Unable to create spill directory, spilling disabled: %s\n", err.Error())
+			config.SpillDir = ""
+		}
+	}
+
+	return &exporter{
+		config:  config,
+		postUrl: host + "/runtime/record",
+		client:  &http.Client{Transport: runtimeTransport, Timeout: 30 * time.Second},
+		queue:   make(chan Record, config.QueueSize),
+		healthy: true,
+		flushes: make(chan chan struct{}),
+		stop:    func() {},
+		done:    make(chan struct{}),
+	}
+}
+
+func (e *exporter) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.stop = cancel
+	go e.run(ctx)
+}
+
+func (e *exporter) Metrics() ExporterMetrics {
+	return ExporterMetrics{
+		Queued:    e.queued.Load(),
+		Sent:      e.sent.Load(),
+		Dropped:   e.dropped.Load(),
+		Failed:    e.failed.Load(),
+		Spilled:   e.spilled.Load(),
+		Retries:   e.retries.Load(),
+		QueueSize: len(e.queue),
+	}
+}
+
+func (e *exporter) Enqueue(r Record) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed.Load() {
+		e.dropped.Add(1)
+		return
+	}
+
+	switch e.config.DropPolicy {
+	case Block:
+		// Senders still waiting once the exporter stopped would wait forever
+		select {
+		case e.queue <- r:
+		case <-e.done:
+			e.dropped.Add(1)
+			return
+		}
+	case DropOldest:
+		for {
+			select {
+			case e.queue <- r:
+				e.queued.Add(1)
+				return
+			default:
+			}
+			select {
+			case <-e.queue:
+				e.dropped.Add(1)
+			default:
+			}
+		}
+	default:
+		select {
+		case e.queue <- r:
+		default:
+			e.dropped.Add(1)
+			return
+		}
+	}
+	e.queued.Add(1)
+}
+
+func (e *exporter) run(ctx context.Context) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]Record, 0, e.config.BatchSize)
+
+	for {
+		select {
+		case r := <-e.queue:
+			batch = append(batch, r)
+			if len(batch) >= e.config.BatchSize {
+				e.flush(ctx, batch)
+				batch = make([]Record, 0, e.config.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				e.flush(ctx, batch)
+				batch = make([]Record, 0, e.config.BatchSize)
+			}
+			e.resendSpilled(ctx)
+		case ack := <-e.flushes:
+			batch = e.drain(ctx, batch)
+			close(ack)
+		case <-ctx.Done():
+			// Whatever couldn't be sent in time is kept on disk for the next start
+			batch = append(batch, e.takeQueued()...)
+			if len(batch) > 0 {
+				if body, err := e.encode(batch); err == nil {
+					e.spill(body, len(batch))
+				} else {
+					e.failed.Add(uint64(len(batch)))
+				}
+			}
+			return
+		}
+	}
+}
+
+// takeQueued empties the queue without waiting
+func (e *exporter) takeQueued() []Record {
+	records := make([]Record, 0, len(e.queue))
+	for {
+		select {
+		case r := <-e.queue:
+			records = append(records, r)
+		default:
+			return records
+		}
+	}
+}
+
+// drain sends the batch and everything queued so far
+func (e *exporter) drain(ctx context.Context, batch []Record) []Record {
+	records := append(batch, e.takeQueued()...)
+	for len(records) > 0 {
+		n := min(len(records), e.config.BatchSize)
+		e.flush(ctx, records[:n])
+		records = records[n:]
+	}
+	return make([]Record, 0, e.config.BatchSize)
+}
+
+// Flush sends everything queued so far and waits for it until ctx is done
+func (e *exporter) Flush(ctx context.Context) error {
+	ack := make(chan struct{})
+	select {
+	case e.flushes <- ack:
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting records and flushes the queue. Records that can't be sent before
+// ctx is done are spilled to disk when a spill directory is configured.
+func (e *exporter) Close(ctx context.Context) error {
+	if !e.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	err := e.Flush(ctx)
+	e.stop()
+	<-e.done
+
+	// Records queued by senders that passed the closed check while the run loop stopped
+	e.mu.Lock()
+	e.dropped.Add(uint64(len(e.takeQueued())))
+	e.mu.Unlock()
+	return err
+}
+
+func (e *exporter) encode(batch []Record) ([]byte, error) {
+	if e.config.Encoding != StreamEncoding {
+		return json.Marshal(batch)
+	}
+
+	buffer := &bytes.Buffer{}
+	rw := newRecordWriter(buffer)
+	for i := range batch {
+		if err := rw.Write(&batch[i]); err != nil {
+			return nil, err
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func (e *exporter) contentType() string {
+	if e.config.Encoding == StreamEncoding {
+		return RecordStreamContentType
+	}
+	return "application/json"
+}
+
+// flush sends a batch, retrying while the runtime is reachable. Once it isn't, batches are
+// spilled straight away until a spilled batch goes through again.
+func (e *exporter) flush(ctx context.Context, batch []Record) {
+	body, err := e.encode(batch)
+	if err != nil {
+		fmt.Printf("Unable to marshal payload: %s\n", err.Error())
+		e.failed.Add(uint64(len(batch)))
+		return
+	}
+
+	retries := e.config.MaxRetries
+	if !e.healthy {
+		retries = 0
+	}
+
+	err = e.sendWithRetry(ctx, body, e.contentType(), retries)
+	switch {
+	case err == nil:
+		e.healthy = true
+		e.sent.Add(uint64(len(batch)))
+	case errors.Is(err, errRejected):
+		fmt.Printf("Unable to post payload: %s\n", err.Error())
+		e.failed.Add(uint64(len(batch)))
+	default:
+		fmt.Printf("Unable to post payload: %s\n", err.Error())
+		e.healthy = false
+		e.spill(body, len(batch))
+	}
+}
+
+func (e *exporter) sendWithRetry(ctx context.Context, body []byte, contentType string, retries int) error {
+	backoff := e.config.RetryBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := e.send(ctx, body, contentType)
+		if err == nil || errors.Is(err, errRejected) || attempt >= retries {
+			return err
+		}
+
+		e.retries.Add(1)
+		// Full jitter keeps services that lost the runtime at the same time from retrying in lockstep
+		delay := backoff/2 + rand.N(backoff/2+1)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff = min(backoff*2, e.config.MaxBackoff)
+	}
+}
+
+func (e *exporter) send(ctx context.Context, body []byte, contentType string) error {
+	var payload io.Reader = bytes.NewReader(body)
+	if e.config.Compress {
+		buffer := bytes.NewBuffer(make([]byte, 0, len(body)/4))
+		zw := gzip.NewWriter(buffer)
+		if _, err := zw.Write(body); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		payload = buffer
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.postUrl, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.config.Compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	authorize(req)
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusAccepted:
+		return nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return fmt.Errorf("invalid status code received: %d", resp.StatusCode)
+	default:
+		return fmt.Errorf("%w, status code: %d", errRejected, resp.StatusCode)
+	}
+}
+
+// Spilled batches are named <unix nano>-<record count>.<encoding> so they sort oldest first
+var spillExtensions = map[string]string{".json": "application/json", ".bin": RecordStreamContentType}
+
+func (e *exporter) spill(body []byte, count int) {
+	if e.config.SpillDir == "" {
+		e.failed.Add(uint64(count))
+		return
+	}
+
+	ext := ".json"
+	if e.config.Encoding == StreamEncoding {
+		ext = ".bin"
+	}
+	name := fmt.Sprintf("%020d-%d%s", time.Now().UnixNano(), count, ext)
+	if err := os.WriteFile(filepath.Join(e.config.SpillDir, name), body, 0o600); err != nil {
+		fmt.Printf("Unable to spill payload: %s\n", err.Error())
+		e.failed.Add(uint64(count))
+		return
+	}
+	e.spilled.Add(uint64(count))
+	e.trimSpill()
+}
+
+type spillFile struct {
+	path        string
+	contentType string
+	count       int
+	size        int64
+}
+
+func (e *exporter) spillFiles() []spillFile {
+	if e.config.SpillDir == "" {
+		return nil
+	}
+
+	entries, err := os.ReadDir(e.config.SpillDir)
+	if err != nil {
+		return nil
+	}
+
+	files := make([]spillFile, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := filepath.Ext(name)
+		contentType, known := spillExtensions[ext]
+		_, countStr, ok := strings.Cut(strings.TrimSuffix(name, ext), "-")
+		if entry.IsDir() || !known || !ok {
+			continue
+		}
+		count, _ := strconv.Atoi(countStr)
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, spillFile{path: filepath.Join(e.config.SpillDir, name), contentType: contentType, count: count, size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+func (e *exporter) trimSpill() {
+	files := e.spillFiles()
+
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+
+	for _, f := range files {
+		if total <= e.config.MaxSpillBytes {
+			return
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+			e.dropped.Add(uint64(f.count))
+		}
+	}
+}
+
+// resendSpilled sends spilled batches oldest first and stops at the first failure
+func (e *exporter) resendSpilled(ctx context.Context) {
+	for _, f := range e.spillFiles() {
+		body, err := os.ReadFile(f.path)
+		if err != nil {
+			continue
+		}
+
+		err = e.send(ctx, body, f.contentType)
+		switch {
+		case err == nil:
+			e.healthy = true
+			e.sent.Add(uint64(f.count))
+		case errors.Is(err, errRejected):
+			fmt.Printf("Spilled payload %s rejected: %s\n", f.path, err.Error())
+			e.failed.Add(uint64(f.count))
+		default:
+			e.healthy = false
+			return
+		}
+		os.Remove(f.path)
+	}
+}
@@ -0,0 +1,186 @@
+package sdk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExporterDropPolicy(t *testing.T) {
+	for policy, want := range map[DropPolicy][2]string{DropNewest: {"0", "1"}, DropOldest: {"1", "2"}} {
+		exp := newExporter("http://localhost", ExporterConfig{QueueSize: 2, DropPolicy: policy})
+		for _, rc := range []string{"0", "1", "2"} {
+			exp.Enqueue(Record{RequestContext: rc})
+		}
+
+		act := [2]string{(<-exp.queue).RequestContext, (<-exp.queue).RequestContext}
+		if act != want {
+			t.Errorf("Want %v Actual %v\n", want, act)
+		}
+		if m := exp.Metrics(); m.Dropped != 1 {
+			t.Errorf("Want %v Actual %v\n", 1, m.Dropped)
+		}
+	}
+}
+
+func TestExporterBlockAfterClose(t *testing.T) {
+	exp := newExporter("http://localhost", ExporterConfig{QueueSize: 1, DropPolicy: Block})
+	exp.Enqueue(Record{RequestContext: "0"})
+
+	blocked := make(chan struct{})
+	go func() {
+		exp.Enqueue(Record{RequestContext: "1"})
+		close(blocked)
+	}()
+
+	// The run loop stops without taking the record the sender waits with
+	close(exp.done)
+	select {
+	case <-blocked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Want %v Actual %v\n", "enqueue returned", "blocked")
+	}
+	if m := exp.Metrics(); m.Dropped != 1 || m.Queued != 1 {
+		t.Errorf("Want %v Actual %+v\n", "1 dropped", m)
+	}
+}
+
+func TestExporterSpill(t *testing.T) {
+	var up atomic.Bool
+	var received atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		records := []Record{}
+		if err := json.NewDecoder(zr).Decode(&records); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Add(int64(len(records)))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	exp := newExporter(server.URL, ExporterConfig{
+		MaxRetries:   1,
+		RetryBackoff: time.Millisecond,
+		Compress:     true,
+		SpillDir:     t.TempDir(),
+	})
+	ctx := context.Background()
+
+	exp.flush(ctx, []Record{{RequestContext: "a"}, {RequestContext: "a"}})
+	exp.flush(ctx, []Record{{RequestContext: "b"}})
+
+	m := exp.Metrics()
+	if m.Spilled != 3 || m.Retries != 1 || len(exp.spillFiles()) != 2 {
+		t.Errorf("Want %v Actual %+v\n", "3 spilled after 1 retry", m)
+	}
+
+	up.Store(true)
+	exp.resendSpilled(ctx)
+
+	if received.Load() != 3 || len(exp.spillFiles()) != 0 {
+		t.Errorf("Want %v Actual %v\n", 3, received.Load())
+	}
+	if m := exp.Metrics(); m.Sent != 3 {
+		t.Errorf("Want %v Actual %+v\n", 3, m)
+	}
+}
+
+func TestExporterClose(t *testing.T) {
+	var received atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		records := []Record{}
+		json.NewDecoder(r.Body).Decode(&records)
+		received.Add(int64(len(records)))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	exp := newExporter(server.URL, ExporterConfig{BatchSize: 2, FlushInterval: time.Hour})
+	exp.start()
+
+	for range 5 {
+		exp.Enqueue(Record{RequestContext: "a"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := exp.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if received.Load() != 5 {
+		t.Errorf("Want %v Actual %v\n", 5, received.Load())
+	}
+
+	exp.Enqueue(Record{RequestContext: "b"})
+	if m := exp.Metrics(); m.Dropped != 1 {
+		t.Errorf("Want %v Actual %v\n", 1, m.Dropped)
+	}
+}
+
+func TestExporterCloseLateRecord(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	exp := newExporter(server.URL, ExporterConfig{FlushInterval: time.Hour})
+	exp.start()
+
+	// A sender that passed the closed check queues its record after the run loop stopped
+	exp.mu.RLock()
+	closed := make(chan error)
+	go func() { closed <- exp.Close(context.Background()) }()
+	<-exp.done
+	exp.queue <- Record{RequestContext: "late"}
+	exp.mu.RUnlock()
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if m := exp.Metrics(); m.Dropped != 1 || m.QueueSize != 0 {
+		t.Errorf("Want %v Actual %+v\n", "1 dropped", m)
+	}
+}
+
+func TestExporterStreamEncoding(t *testing.T) {
+	contentType := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !bytes.HasPrefix(body, append([]byte(recordStreamMagic), recordStreamVersion)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		contentType <- r.Header.Get("Content-Type")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	exp := newExporter(server.URL, ExporterConfig{Encoding: StreamEncoding})
+	exp.flush(context.Background(), []Record{{RequestContext: "rc", Body: []byte("body")}})
+
+	if m := exp.Metrics(); m.Sent != 1 {
+		t.Fatalf("Want %v Actual %v\n", 1, m.Sent)
+	}
+	if act := <-contentType; act != RecordStreamContentType {
+		t.Errorf("Want %v Actual %v\n", RecordStreamContentType, act)
+	}
+}
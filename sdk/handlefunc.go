@@ -23,10 +23,10 @@ func WithAudit(handler func(http.ResponseWriter, *http.Request)) func(http.Respo
 		bodyBytes := readAndRestore(&r.Body)
 		rw := NewResponseWritter(w, serviceContext)
 
-		if !serviceContext.Debug {
-			go func() {
+		if serviceContext.Recording() {
+			goLog(func() {
 				Log(Record{
-					RequestContext:     serviceContext.RequestContext,
+					RequestContext:     serviceContext.RecordContext(),
 					CauseContext:       serviceContext.CauseContext,
 					ExecutionContext:   serviceContext.ExecutionContext,
 					RecordType:         RequestRecordType,
@@ -42,15 +42,22 @@ func WithAudit(handler func(http.ResponseWriter, *http.Request)) func(http.Respo
 					Body:               bodyBytes,
 					StatusCode:         0,
 				})
-			}()
+			})
 		}
 
 		defer func() {
+			var recovered any
+			if flushOnPanic.Load() {
+				if recovered = recover(); recovered != nil && !rw.written {
+					rw.status = http.StatusInternalServerError
+				}
+			}
+
 			duration := time.Since(start).Milliseconds()
-			if !serviceContext.Debug {
-				go func() {
+			if serviceContext.Recording() {
+				goLog(func() {
 					Log(Record{
-						RequestContext:     serviceContext.RequestContext,
+						RequestContext:     serviceContext.RecordContext(),
 						CauseContext:       serviceContext.CauseContext,
 						ExecutionContext:   serviceContext.ExecutionContext,
 						RecordType:         ResponseRecordType,
@@ -66,7 +73,12 @@ func WithAudit(handler func(http.ResponseWriter, *http.Request)) func(http.Respo
 						Body:               rw.buffer,
 						StatusCode:         rw.status,
 					})
-				}()
+				})
+			}
+
+			if recovered != nil {
+				flushAfterPanic()
+				panic(recovered)
 			}
 		}()
 
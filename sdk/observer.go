@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/binary"
 	"encoding/gob"
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"time"
@@ -35,41 +36,16 @@ func (o *StateObserver[T]) Observe(ctx context.Context, value T) T {
 
 	if sc.Debug {
 		if data, ok := sc.ObservationData(o.name, seq); ok {
-			val, err := o.Unmarshal(data.Body)
+			val, err := o.unmarshalData(data)
 			if err != nil {
 				fmt.Printf("Unmarshalling error for %s Error: %s\n", o.name, err.Error())
-				return value
-			}
-			return val
-		}
-	} else {
-		go func() {
-			if outBody, err := o.Marshal(value); err == nil {
-				Log(Record{
-					RequestContext:      sc.RequestContext,
-					CauseContext:        sc.CauseContext,
-					ExecutionContext:    sc.ExecutionContext,
-					RecordType:          ObservedRecordType,
-					Method:              "",
-					Time:                time.Now(),
-					Duration:            0,
-					DepencencySequence:  0,
-					ScopedSequence:      seq,
-					ObservationSequence: oq,
-					ServiceName:         serviceName,
-					ObservationName:     o.name,
-					Host:                "",
-					Uri:                 "",
-					Header:              nil,
-					Body:                outBody,
-					StatusCode:          0,
-				})
 			} else {
-				fmt.Printf("Error enocding observation: %s\n", err.Error())
+				value = val
 			}
-		}()
+		}
 	}
 
+	o.log(sc, seq, oq, value, nil)
 	return value
 }
 
@@ -85,36 +61,15 @@ func (o *StateObserver[T]) ObserveWithErr(ctx context.Context, value T) (T, erro
 
 	if sc.Debug {
 		if data, ok := sc.ObservationData(o.name, seq); ok {
-			return o.Unmarshal(data.Body)
-		}
-	} else {
-		go func() {
-			if outBody, err := o.Marshal(value); err == nil {
-				Log(Record{
-					RequestContext:      sc.RequestContext,
-					CauseContext:        sc.CauseContext,
-					ExecutionContext:    sc.ExecutionContext,
-					RecordType:          ObservedRecordType,
-					Method:              "",
-					Time:                time.Now(),
-					Duration:            0,
-					DepencencySequence:  0,
-					ScopedSequence:      seq,
-					ObservationSequence: oq,
-					ServiceName:         serviceName,
-					ObservationName:     o.name,
-					Host:                "",
-					Uri:                 "",
-					Header:              nil,
-					Body:                outBody,
-					StatusCode:          0,
-				})
-			} else {
-				fmt.Printf("Error enocding observation: %s\n", err.Error())
+			val, err := o.unmarshalData(data)
+			if err == nil {
+				o.log(sc, seq, oq, val, nil)
 			}
-		}()
+			return val, err
+		}
 	}
 
+	o.log(sc, seq, oq, value, nil)
 	return value, nil
 }
 
@@ -131,44 +86,19 @@ func (o *StateObserver[T]) ObserveFunc(ctx context.Context, valueFunc func() T)
 
 	if sc.Debug {
 		if data, ok := sc.ObservationData(o.name, seq); ok {
-			val, err := o.Unmarshal(data.Body)
+			val, err := o.unmarshalData(data)
 			if err != nil {
 				fmt.Printf("Unmarshalling error for %s Error: %s\n", o.name, err.Error())
-				return valueFunc()
-			}
-			return val
-		}
-		return valueFunc()
-	} else {
-		value := valueFunc()
-		go func() {
-			if outBody, err := o.Marshal(value); err == nil {
-				Log(Record{
-					RequestContext:      sc.RequestContext,
-					CauseContext:        sc.CauseContext,
-					ExecutionContext:    sc.ExecutionContext,
-					RecordType:          ObservedRecordType,
-					Method:              "",
-					Time:                time.Now(),
-					Duration:            0,
-					DepencencySequence:  0,
-					ScopedSequence:      seq,
-					ObservationSequence: oq,
-					ServiceName:         serviceName,
-					ObservationName:     o.name,
-					Host:                "",
-					Uri:                 "",
-					Header:              nil,
-					Body:                outBody,
-					StatusCode:          0,
-				})
 			} else {
-				fmt.Printf("Error enocding observation: %s\n", err.Error())
+				o.log(sc, seq, oq, val, nil)
+				return val
 			}
-		}()
-
-		return value
+		}
 	}
+
+	value := valueFunc()
+	o.log(sc, seq, oq, value, nil)
+	return value
 }
 
 func (o *StateObserver[T]) ObserveFuncWithErr(ctx context.Context, valueFunc func() (T, error)) (T, error) {
@@ -186,51 +116,64 @@ func (o *StateObserver[T]) ObserveFuncWithErr(ctx context.Context, valueFunc fun
 		if data, ok := sc.ObservationData(o.name, seq); ok {
 
 			if data.ObservationError != nil {
-				val, _ := o.Unmarshal(data.Body)
-				return val, fmt.Errorf("%s", string(data.ObservationError))
+				val, _ := o.unmarshalData(data)
+				valueErr := fmt.Errorf("%s", string(data.ObservationError))
+				o.log(sc, seq, oq, val, valueErr)
+				return val, valueErr
 			}
 
-			return o.Unmarshal(data.Body)
-		}
-		return valueFunc()
-	} else {
-		value, valueErr := valueFunc()
-		go func() {
-			if outBody, err := o.Marshal(value); err == nil {
-
-				var errorBody []byte
-
-				if valueErr != nil {
-					errorBody = []byte(valueErr.Error())
-				}
-
-				Log(Record{
-					RequestContext:      sc.RequestContext,
-					CauseContext:        sc.CauseContext,
-					ExecutionContext:    sc.ExecutionContext,
-					RecordType:          ObservedRecordType,
-					Method:              "",
-					Time:                time.Now(),
-					Duration:            0,
-					DepencencySequence:  0,
-					ScopedSequence:      seq,
-					ObservationSequence: oq,
-					ServiceName:         serviceName,
-					ObservationName:     o.name,
-					Host:                "",
-					Uri:                 "",
-					Header:              nil,
-					Body:                outBody,
-					ObservationError:    errorBody,
-					StatusCode:          0,
-				})
-			} else {
-				fmt.Printf("Error enocding observation: %s\n", err.Error())
+			val, err := o.unmarshalData(data)
+			if err == nil {
+				o.log(sc, seq, oq, val, nil)
 			}
-		}()
+			return val, err
+		}
+	}
+
+	value, valueErr := valueFunc()
+	o.log(sc, seq, oq, value, valueErr)
+	return value, valueErr
+}
 
-		return value, valueErr
+// log records an observed value, in debug mode that's the value the replay used
+func (o *StateObserver[T]) log(sc *ServiceContext, seq, oq int, value T, valueErr error) {
+	if !sc.Recording() {
+		return
 	}
+	goLog(func() {
+		outBody, err := o.Marshal(value)
+		if err != nil {
+			fmt.Printf("Error enocding observation: %s\n", err.Error())
+			return
+		}
+
+		var errorBody []byte
+
+		if valueErr != nil {
+			errorBody = []byte(valueErr.Error())
+		}
+
+		Log(Record{
+			RequestContext:      sc.RecordContext(),
+			CauseContext:        sc.CauseContext,
+			ExecutionContext:    sc.ExecutionContext,
+			RecordType:          ObservedRecordType,
+			Method:              "",
+			Time:                time.Now(),
+			Duration:            0,
+			DepencencySequence:  0,
+			ScopedSequence:      seq,
+			ObservationSequence: oq,
+			ServiceName:         serviceName,
+			ObservationName:     o.name,
+			Host:                "",
+			Uri:                 "",
+			Header:              nil,
+			Body:                outBody,
+			ObservationError:    errorBody,
+			StatusCode:          0,
+		})
+	})
 }
 
 func (o *StateObserver[T]) Marshal(value T) ([]byte, error) {
@@ -248,6 +191,16 @@ func (o *StateObserver[T]) Unmarshal(data []byte) (T, error) {
 	return o.decode(data)
 }
 
+// unmarshalData decodes a value served by the runtime, overrides are JSON since the runtime doesn't know T
+func (o *StateObserver[T]) unmarshalData(data ObservationData) (T, error) {
+	if data.Override {
+		var value T
+		err := json.Unmarshal(data.Body, &value)
+		return value, err
+	}
+	return o.Unmarshal(data.Body)
+}
+
 func NewStateObserver[T any](name string) *StateObserver[T] {
 	var value T
 	vt := reflect.TypeOf(value)
@@ -144,3 +144,28 @@ func TestArray(t *testing.T) {
 		t.Errorf("Want %v Actual %v\n", want, act)
 	}
 }
+
+func TestOverride(t *testing.T) {
+	so := NewStateObserver[int]("Test")
+
+	act, err := so.unmarshalData(ObservationData{Body: []byte(`0`), Override: true})
+	if err != nil || act != 0 {
+		t.Errorf("Want %v Actual %v %v\n", 0, act, err)
+	}
+
+	data, _ := so.Marshal(42)
+	act, err = so.unmarshalData(ObservationData{Body: data})
+	if err != nil || act != 42 {
+		t.Errorf("Want %v Actual %v %v\n", 42, act, err)
+	}
+
+	type Data struct {
+		Name string
+		Age  int
+	}
+	sd := NewStateObserver[Data]("Test")
+	want := Data{Name: "John Doe", Age: 0}
+	if act, err := sd.unmarshalData(ObservationData{Body: []byte(`{"Name": "John Doe", "Age": 0}`), Override: true}); err != nil || act != want {
+		t.Errorf("Want %v Actual %v %v\n", want, act, err)
+	}
+}
@@ -30,6 +30,8 @@ type Record struct {
 	Uri                 string              `json:"ru"`
 	Header              map[string][]string `json:"he"`
 	Body                []byte              `json:"bd"`
-	ObservationError    []byte              `json:"oe"`
+	ObservationError    []byte              `json:"oe"` // Error of an observation
 	StatusCode          int                 `json:"st"`
+	Redacted            []string            `json:"rd,omitempty"` // Paths of the JSON body redacted by the SDK, like items.0.card
+	TransportError      string              `json:"te,omitempty"` // Error of a dependency call that got no response, like "refused: dial tcp ..."
 }
@@ -0,0 +1,249 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var DefaultRedactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+// RedactionConfig describes sensitive data removed from records before they leave the service.
+// Redacted values are replaced with tokens derived from the value, so equal values still
+// compare equal during replay. Services of the same system should share the Key.
+type RedactionConfig struct {
+	Headers   []string         // Names of headers whose values are redacted
+	BodyPaths []string         // JSON paths redacted in bodies, like user.email or items[*].card
+	Patterns  []*regexp.Regexp // Matches are redacted in header values, bodies and errors
+	Key       []byte           // Key of the tokens, a random key of the process is used when empty
+}
+
+type Redactor struct {
+	headers  map[string]bool
+	paths    [][]string
+	patterns []*regexp.Regexp
+	key      []byte
+}
+
+func NewRedactor(config RedactionConfig) *Redactor {
+	// Without a key the tokens of short values like numbers could be reversed by trying every value
+	if len(config.Key) == 0 {
+		config.Key = make([]byte, 32)
+		rand.Read(config.Key)
+		fmt.Println("WARNING: no redaction key configured, redacted values only compare equal within this process")
+	}
+
+	rd := &Redactor{
+		headers:  make(map[string]bool, len(config.Headers)),
+		paths:    make([][]string, 0, len(config.BodyPaths)),
+		patterns: config.Patterns,
+		key:      config.Key,
+	}
+
+	for _, h := range config.Headers {
+		rd.headers[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, p := range config.BodyPaths {
+		if path := parseJSONPath(p); len(path) > 0 {
+			rd.paths = append(rd.paths, path)
+		}
+	}
+	return rd
+}
+
+// parseJSONPath splits a.b[*].c[0] into a, b, *, c, 0
+func parseJSONPath(path string) []string {
+	retval := make([]string, 0)
+	for _, seg := range strings.Split(path, ".") {
+		for seg != "" {
+			i := strings.Index(seg, "[")
+			if i == -1 {
+				retval = append(retval, seg)
+				break
+			}
+			if i > 0 {
+				retval = append(retval, seg[:i])
+			}
+			j := strings.Index(seg[i:], "]")
+			if j == -1 {
+				retval = append(retval, seg[i+1:])
+				break
+			}
+			retval = append(retval, seg[i+1:i+j])
+			seg = seg[i+j+1:]
+		}
+	}
+	return retval
+}
+
+func (rd *Redactor) sum(value []byte) []byte {
+	mac := hmac.New(sha256.New, rd.key)
+	mac.Write(value)
+	return mac.Sum(nil)
+}
+
+// Token returns the redacted form of a value
+func (rd *Redactor) Token(value string) string {
+	return "redacted:" + hex.EncodeToString(rd.sum([]byte(value))[:12])
+}
+
+// numberToken keeps redacted numbers numeric so the body still decodes into the same types
+func (rd *Redactor) numberToken(value json.Number) json.Number {
+	return json.Number(strconv.FormatUint(binary.BigEndian.Uint64(rd.sum([]byte(value)))%1_000_000_000, 10))
+}
+
+func (rd *Redactor) Redact(r Record) Record {
+	r.Header = rd.redactHeader(r.Header)
+
+	// Observation bodies are encoded by the observer and can't be altered without breaking the decoding
+	if r.RecordType != ObservedRecordType {
+		r.Body, r.Redacted = rd.redactBody(r.Body)
+	}
+	if len(r.ObservationError) > 0 {
+		r.ObservationError = rd.scrub(r.ObservationError)
+	}
+	if r.TransportError != "" {
+		r.TransportError = string(rd.scrub([]byte(r.TransportError)))
+	}
+	return r
+}
+
+func (rd *Redactor) redactHeader(header map[string][]string) map[string][]string {
+	if header == nil {
+		return nil
+	}
+
+	// The header usually belongs to a live request, so it's never modified in place
+	retval := make(map[string][]string, len(header))
+	for name, vals := range header {
+		redacted := make([]string, len(vals))
+		for i, v := range vals {
+			if rd.headers[http.CanonicalHeaderKey(name)] {
+				redacted[i] = rd.Token(v)
+			} else {
+				redacted[i] = string(rd.scrub([]byte(v)))
+			}
+		}
+		retval[name] = redacted
+	}
+	return retval
+}
+
+// redactBody also returns the paths of the JSON body it redacted, so a replay can tell a
+// redacted value from one that changed
+func (rd *Redactor) redactBody(body []byte) ([]byte, []string) {
+	if len(body) == 0 {
+		return body, nil
+	}
+
+	var redacted []string
+	if len(rd.paths) > 0 {
+		if masked, paths, ok := rd.maskJSON(body); ok {
+			body, redacted = masked, paths
+		}
+	}
+	return rd.scrub(body), redacted
+}
+
+func (rd *Redactor) scrub(data []byte) []byte {
+	for _, p := range rd.patterns {
+		data = p.ReplaceAllFunc(data, func(match []byte) []byte {
+			return []byte(rd.Token(string(match)))
+		})
+	}
+	return data
+}
+
+func (rd *Redactor) maskJSON(body []byte) ([]byte, []string, bool) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, nil, false
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var value any
+	if err := dec.Decode(&value); err != nil {
+		return nil, nil, false
+	}
+
+	// More than one value, like newline delimited JSON, can't be re-encoded as one
+	if dec.More() {
+		return nil, nil, false
+	}
+
+	redacted := make([]string, 0)
+	for _, path := range rd.paths {
+		value = rd.mask(value, path, "", &redacted)
+	}
+	// Bodies without redacted values are kept as they are
+	if len(redacted) == 0 {
+		return nil, nil, false
+	}
+
+	masked := &bytes.Buffer{}
+	enc := json.NewEncoder(masked)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(value); err != nil {
+		return nil, nil, false
+	}
+	return bytes.TrimSuffix(masked.Bytes(), []byte("\n")), redacted, true
+}
+
+// mask redacts the values of path, the paths of the redacted values are added to redacted
+func (rd *Redactor) mask(value any, path []string, at string, redacted *[]string) any {
+	if len(path) == 0 {
+		*redacted = append(*redacted, at)
+		return rd.tokenValue(value)
+	}
+
+	switch v := value.(type) {
+	case map[string]any:
+		if path[0] == "*" {
+			for k := range v {
+				v[k] = rd.mask(v[k], path[1:], joinPath(at, k), redacted)
+			}
+		} else if child, ok := v[path[0]]; ok {
+			v[path[0]] = rd.mask(child, path[1:], joinPath(at, path[0]), redacted)
+		}
+	case []any:
+		if path[0] == "*" {
+			for i := range v {
+				v[i] = rd.mask(v[i], path[1:], joinPath(at, strconv.Itoa(i)), redacted)
+			}
+		} else if i, err := strconv.Atoi(path[0]); err == nil && i >= 0 && i < len(v) {
+			v[i] = rd.mask(v[i], path[1:], joinPath(at, path[0]), redacted)
+		}
+	}
+	return value
+}
+
+func joinPath(at, key string) string {
+	if at == "" {
+		return key
+	}
+	return at + "." + key
+}
+
+func (rd *Redactor) tokenValue(value any) any {
+	switch v := value.(type) {
+	case nil, bool:
+		return v
+	case string:
+		return rd.Token(v)
+	case json.Number:
+		return rd.numberToken(v)
+	default:
+		data, _ := json.Marshal(v)
+		return rd.Token(string(data))
+	}
+}
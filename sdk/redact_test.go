@@ -0,0 +1,116 @@
+package sdk
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestRedactHeader(t *testing.T) {
+	rd := NewRedactor(RedactionConfig{Headers: DefaultRedactedHeaders, Key: []byte("key")})
+
+	header := map[string][]string{
+		"Authorization": {"Bearer secret"},
+		"Accept":        {"application/json"},
+	}
+	r := rd.Redact(Record{Header: header})
+
+	if header["Authorization"][0] != "Bearer secret" {
+		t.Errorf("Original header modified")
+	}
+	if r.Header["Authorization"][0] != rd.Token("Bearer secret") {
+		t.Errorf("Want %v Actual %v\n", rd.Token("Bearer secret"), r.Header["Authorization"][0])
+	}
+	if r.Header["Accept"][0] != "application/json" {
+		t.Errorf("Want %v Actual %v\n", "application/json", r.Header["Accept"][0])
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	rd := NewRedactor(RedactionConfig{
+		BodyPaths: []string{"user.email", "cards[*].number", "age"},
+		Patterns:  []*regexp.Regexp{regexp.MustCompile(`\d{3}-\d{2}-\d{4}`)},
+		Key:       []byte("key"),
+	})
+
+	body := `{"user":{"email":"jane@example.com","name":"Jane"},"cards":[{"number":"4111"},{"number":"4222"}],"age":42,"note":"ssn 123-45-6789"}`
+	r := rd.Redact(Record{RecordType: RequestRecordType, Body: []byte(body)})
+
+	var act struct {
+		User struct {
+			Email string `json:"email"`
+			Name  string `json:"name"`
+		} `json:"user"`
+		Cards []struct {
+			Number string `json:"number"`
+		} `json:"cards"`
+		Age  int    `json:"age"`
+		Note string `json:"note"`
+	}
+	if err := json.Unmarshal(r.Body, &act); err != nil {
+		t.Fatal(err)
+	}
+
+	if act.User.Email != rd.Token("jane@example.com") {
+		t.Errorf("Want %v Actual %v\n", rd.Token("jane@example.com"), act.User.Email)
+	}
+	if act.User.Name != "Jane" {
+		t.Errorf("Want %v Actual %v\n", "Jane", act.User.Name)
+	}
+	if act.Cards[1].Number != rd.Token("4222") {
+		t.Errorf("Want %v Actual %v\n", rd.Token("4222"), act.Cards[1].Number)
+	}
+	if act.Age == 42 {
+		t.Errorf("Number not redacted")
+	}
+	if strings.Contains(act.Note, "123-45-6789") {
+		t.Errorf("Pattern not redacted %s", act.Note)
+	}
+	if want := "user.email,cards.0.number,cards.1.number,age"; strings.Join(r.Redacted, ",") != want {
+		t.Errorf("Want %v Actual %v\n", want, r.Redacted)
+	}
+
+	// Deterministic tokens
+	again := rd.Redact(Record{RecordType: RequestRecordType, Body: []byte(body)})
+	if string(again.Body) != string(r.Body) {
+		t.Errorf("Want %s Actual %s\n", r.Body, again.Body)
+	}
+}
+
+func TestParseJSONPath(t *testing.T) {
+	want := []string{"a", "b", "*", "c", "0"}
+	act := parseJSONPath("a.b[*].c[0]")
+	if strings.Join(act, ",") != strings.Join(want, ",") {
+		t.Errorf("Want %v Actual %v\n", want, act)
+	}
+}
+
+func TestRedactBodyUnchanged(t *testing.T) {
+	rd := NewRedactor(RedactionConfig{BodyPaths: []string{"user.email"}, Key: []byte("key")})
+
+	// Bodies without a redacted path keep their key order and escaping, newline delimited JSON is left alone
+	for _, body := range []string{
+		`{"b": "<a&b>", "a": 1}`,
+		"{\"user\": {\"email\": \"jane@example.com\"}}\n{\"user\": {\"email\": \"john@example.com\"}}\n",
+	} {
+		if r := rd.Redact(Record{RecordType: RequestRecordType, Body: []byte(body)}); string(r.Body) != body || r.Redacted != nil {
+			t.Errorf("Want %v Actual %s %v\n", body, r.Body, r.Redacted)
+		}
+	}
+
+	r := rd.Redact(Record{RecordType: RequestRecordType, Body: []byte(`{"user": {"email": "jane@example.com"}, "note": "<b>"}`)})
+	if want := `{"note":"<b>","user":{"email":"` + rd.Token("jane@example.com") + `"}}`; string(r.Body) != want {
+		t.Errorf("Want %v Actual %s\n", want, r.Body)
+	}
+}
+
+func TestRedactorRandomKey(t *testing.T) {
+	a, b := NewRedactor(RedactionConfig{}), NewRedactor(RedactionConfig{})
+	if a.Token("4111") != a.Token("4111") {
+		t.Errorf("Tokens of the same redactor differ")
+	}
+	if a.Token("4111") == b.Token("4111") || a.numberToken("4111") == b.numberToken("4111") {
+		t.Errorf("Tokens of redactors without a key are equal")
+	}
+}
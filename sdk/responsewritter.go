@@ -33,6 +33,7 @@ func (w *ResponseWritter) addContextHeaders() {
 		w.orig.Header().Add(RequestContextHeader, w.serviceContext.RequestContext)
 		w.orig.Header().Add(CauseContextHeader, w.serviceContext.CauseContext)
 		w.orig.Header().Add(ExecutionContextHeader, w.serviceContext.ExecutionContext)
+		w.orig.Header().Add(CaptureHeader, captureHeaderValue(w.serviceContext.Capture))
 	}
 }
 
@@ -25,14 +25,25 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	dependencyContext := sc.NewExecutionID()
 
 	// inject headers for downstream services
-	req.Header.Set(RequestContextHeader, sc.RequestContext)   // Request context propagates as is
-	req.Header.Set(CauseContextHeader, sc.ExecutionContext)   // Current execution is dependencies Cause for execution
-	req.Header.Set(ExecutionContextHeader, dependencyContext) // Each dependency call get't it's own unique execution context
+	req.Header.Set(RequestContextHeader, sc.RequestContext)       // Request context propagates as is
+	req.Header.Set(CauseContextHeader, sc.ExecutionContext)       // Current execution is dependencies Cause for execution
+	req.Header.Set(ExecutionContextHeader, dependencyContext)     // Each dependency call get't it's own unique execution context
+	req.Header.Set(CaptureHeader, captureHeaderValue(sc.Capture)) // Downstream services follow the edge sampling decision
+
+	// recorded as the call was made, the debug headers and URL below are only meant for the runtime
+	header, uri, originalUrl := req.Header, req.URL.String(), req.URL
 	if sc.Debug {
+		header = req.Header.Clone()
 		req.Header.Set(ServiceDebugHeader, DebugEnabled)
 		req.Header.Set(DebugConfigHeader, sc.DebugConfig)
 		req.Header.Set(DepencencySequenceHeader, strconv.Itoa(gsq))
 		req.Header.Set(ScopedDependencySequenceHeader, strconv.Itoa(seq))
+		if sc.ReplaySession != "" {
+			req.Header.Set(ReplaySessionHeader, sc.ReplaySession)
+		}
+		if sc.ReplayContext != "" {
+			req.Header.Set(ReplayContextHeader, sc.ReplayContext)
+		}
 		// DEBUG mode: If debug mode is enabled, we replace the URL with debug URL and let debug host decide what to do with it
 		debugUrl, err := url.Parse(sc.DebugHost)
 		if err != nil {
@@ -42,6 +53,7 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 		q.Add("ref", req.URL.String())
 		debugUrl.RawQuery = q.Encode()
 		req.URL = debugUrl
+		authorize(req)
 	}
 
 	// capture outbound request body (bounded) while preserving for transport
@@ -49,10 +61,10 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	start := time.Now()
 
-	if !sc.Debug {
-		go func() {
+	logRequest := func(dependencyContext string) {
+		goLog(func() {
 			Log(Record{
-				RequestContext:     sc.RequestContext,
+				RequestContext:     sc.RecordContext(),
 				CauseContext:       sc.CauseContext,
 				ExecutionContext:   sc.ExecutionContext,
 				DependencyContext:  dependencyContext,
@@ -64,22 +76,47 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				ScopedSequence:     seq,
 				ServiceName:        serviceName,
 				Host:               req.Host,
-				Uri:                req.URL.String(),
-				Header:             req.Header,
+				Uri:                uri,
+				Header:             header,
 				Body:               outBody,
 				StatusCode:         0,
 			})
-		}()
+		})
+	}
+
+	if sc.Recording() && !sc.Debug {
+		logRequest(dependencyContext)
+	}
+
+	base := t.Base
+	if sc.Debug {
+		// Debug requests go to the runtime instead of the dependency
+		base = runtimeTransport
 	}
 
-	resp, err := t.Base.RoundTrip(req)
+	resp, err := base.RoundTrip(req)
 	duration := time.Since(start).Milliseconds()
 
+	if sc.Debug && err == nil && resp.Header.Get(TransportErrorHeader) != "" {
+		// The recorded call failed, the service gets the same kind of error for the same URL
+		resp.Body.Close()
+		resp, err = nil, decodeTransportError(resp.Header.Get(TransportErrorHeader))
+		req.URL = originalUrl
+	}
+
+	if sc.Recording() && sc.Debug {
+		// A call the runtime forwarded ran as the recorded execution, the recorded replay links to it
+		if err == nil && resp.Header.Get(ExecutionContextHeader) != "" {
+			dependencyContext = resp.Header.Get(ExecutionContextHeader)
+		}
+		logRequest(dependencyContext)
+	}
+
 	if err != nil {
-		if !sc.Debug {
-			go func() {
+		if sc.Recording() {
+			goLog(func() {
 				Log(Record{
-					RequestContext:     sc.RequestContext,
+					RequestContext:     sc.RecordContext(),
 					CauseContext:       sc.CauseContext,
 					ExecutionContext:   sc.ExecutionContext,
 					DependencyContext:  dependencyContext,
@@ -91,12 +128,13 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 					ScopedSequence:     seq,
 					ServiceName:        serviceName,
 					Host:               req.Host,
-					Uri:                req.URL.String(),
+					Uri:                uri,
 					Header:             nil,
 					Body:               nil,
-					StatusCode:         resp.StatusCode,
+					TransportError:     encodeTransportError(err),
+					StatusCode:         0,
 				})
-			}()
+			})
 		}
 		return nil, err
 	}
@@ -104,10 +142,10 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	// capture response body (bounded) while preserving for caller
 	respBody := readAndRestore(&resp.Body)
 
-	if !sc.Debug {
-		go func() {
+	if sc.Recording() {
+		goLog(func() {
 			Log(Record{
-				RequestContext:     sc.RequestContext,
+				RequestContext:     sc.RecordContext(),
 				CauseContext:       sc.CauseContext,
 				ExecutionContext:   sc.ExecutionContext,
 				DependencyContext:  dependencyContext,
@@ -119,12 +157,12 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 				ScopedSequence:     seq,
 				ServiceName:        serviceName,
 				Host:               req.Host,
-				Uri:                req.URL.String(),
+				Uri:                uri,
 				Header:             resp.Header,
 				Body:               respBody,
 				StatusCode:         resp.StatusCode,
 			})
-		}()
+		})
 	}
 
 	// restore resp.Body already done by readAndRestore
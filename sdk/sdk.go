@@ -1,91 +1,135 @@
 package sdk
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
 	"net/http"
-	"time"
+	"sync"
 )
 
 var (
-	serviceName  string
-	logEnabled   bool
-	debugEnabled bool
-	systemHost   string
-	debugHost    string
-	observerHost string
-	feeder       chan<- Record
-	cancelFunc   context.CancelFunc
+	serviceName    string
+	logEnabled     bool
+	debugEnabled   bool
+	systemHost     string
+	debugHost      string
+	observerHost   string
+	captureConfig  CaptureConfig
+	redactor       *Redactor
+	recordExporter *exporter
+	logs           sync.WaitGroup
 )
 
+type Config struct {
+	Log       bool
+	Debug     bool
+	Capture   CaptureConfig
+	Redaction RedactionConfig
+	Auth      AuthConfig
+	Exporter  ExporterConfig
+}
+
+// DefaultConfig records every request, redacts credentials and allows debugging
+func DefaultConfig() Config {
+	return Config{
+		Log:   true,
+		Debug: true,
+		Capture: CaptureConfig{
+			SampleRate:  1,
+			ForceHeader: ForceCaptureHeader,
+		},
+		Redaction: RedactionConfig{
+			Headers: DefaultRedactedHeaders,
+		},
+		Exporter: DefaultExporterConfig(),
+	}
+}
+
 func Init(name, host string, log, debug bool) {
+	config := DefaultConfig()
+	config.Log = log
+	config.Debug = debug
+	InitWithConfig(name, host, config)
+}
+
+func InitWithConfig(name, host string, config Config) {
 	serviceName = name
 	systemHost = host
 	debugHost = systemHost + "/runtime/proxy"
 	observerHost = systemHost + "/runtime/observations"
-	logEnabled = log
-	debugEnabled = debug
+	logEnabled = config.Log
+	debugEnabled = config.Debug
+	captureConfig = config.Capture.withDefaults()
+	redactor = NewRedactor(config.Redaction)
+	runtimeToken = config.Auth.Token
+	runtimeTransport = newRuntimeTransport(config.Auth.TLS)
+	ObserverClient.Transport = runtimeTransport
 	InstrumentClient(DefaultClient)
 	InstrumentClient(http.DefaultClient) // This is to make force an error when http.Get or http.Post is called
-	feeder, cancelFunc = processInBackground(systemHost)
+	recordExporter = newExporter(systemHost, config.Exporter)
+	recordExporter.start()
 }
 
-func Close() {
-	if cancelFunc != nil {
-		cancelFunc()
+// Close waits for records still being logged and sends everything queued to the runtime.
+// Records that can't be sent before ctx is done are spilled to disk when a spill directory is configured.
+func Close(ctx context.Context) error {
+	if recordExporter == nil {
+		return nil
+	}
+	if err := waitForLogs(ctx); err != nil {
+		recordExporter.Close(ctx)
+		return err
 	}
+	return recordExporter.Close(ctx)
 }
 
-func Log(r Record) {
-	if feeder != nil {
-		feeder <- r
+// Flush sends everything logged so far to the runtime and waits for it until ctx is done
+func Flush(ctx context.Context) error {
+	if recordExporter == nil {
+		return nil
+	}
+	if err := waitForLogs(ctx); err != nil {
+		return err
 	}
+	return recordExporter.Flush(ctx)
 }
 
-func processInBackground(host string) (chan<- Record, context.CancelFunc) {
-	feeder := make(chan Record)
-	ctx, cancelFunc := context.WithCancel(context.Background())
-
-	go func(feederChan <-chan Record, ctx context.Context) {
-		records := make([]Record, 0, 100)
-		postUrl := host + "/runtime/record"
-		client := &http.Client{}
-
-		for {
-			select {
-			case r := <-feederChan:
-				records = append(records, r)
-			case <-time.Tick(5 * time.Second):
+// goLog logs in the background, Flush and Close wait for it
+func goLog(f func()) {
+	logs.Add(1)
+	go func() {
+		defer logs.Done()
+		f()
+	}()
+}
 
-				data := make([]Record, len(records))
-				copy(data, records)
-				records = records[:0]
+func waitForLogs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		logs.Wait()
+		close(done)
+	}()
 
-				if len(data) > 0 {
-					go func(data []Record) {
-						body, err := json.Marshal(data)
-						if err != nil {
-							fmt.Printf("Unable to marshal payload: %s", err.Error())
-							return
-						}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-						resp, err := client.Post(postUrl, "application/json", bytes.NewBuffer(body))
-						if err != nil {
-							fmt.Printf("Unable to post payload: %s", err.Error())
-							return
-						}
-						if resp.StatusCode != http.StatusAccepted {
-							fmt.Printf("Invalid status code received: %d\n", resp.StatusCode)
-						}
-					}(data)
-				}
-			case <-ctx.Done():
-				return
-			}
+func Log(r Record) {
+	if recordExporter != nil {
+		if redactor != nil {
+			r = redactor.Redact(r)
 		}
-	}(feeder, ctx)
+		recordExporter.Enqueue(r)
+	}
+}
 
-	return feeder, cancelFunc
+// Metrics reports what happened to the records logged so far
+func Metrics() ExporterMetrics {
+	if recordExporter == nil {
+		return ExporterMetrics{}
+	}
+	return recordExporter.Metrics()
 }
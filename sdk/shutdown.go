@@ -0,0 +1,56 @@
+package sdk
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+var (
+	flushOnPanic atomic.Bool
+	flushTimeout atomic.Int64
+)
+
+// FlushOnExit makes sure the last request trees reach the runtime before the service goes down.
+// On SIGINT or SIGTERM the SDK is closed and the process exits, a panic in a handler wrapped
+// with WithAudit is recorded as a 500 response and flushed before it propagates.
+// Services with their own graceful shutdown should call Close from it instead of using the signal handling.
+func FlushOnExit(timeout time.Duration) {
+	flushTimeout.Store(int64(timeout))
+	flushOnPanic.Store(true)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		sig := <-signals
+		signal.Stop(signals)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+
+		if err := Close(ctx); err != nil {
+			fmt.Printf("Unable to flush records before exit: %s\n", err.Error())
+		}
+
+		code := 1
+		if s, ok := sig.(syscall.Signal); ok {
+			code = 128 + int(s)
+		}
+		os.Exit(code)
+	}()
+}
+
+// flushAfterPanic is called by WithAudit before a recovered panic is raised again
+func flushAfterPanic() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(flushTimeout.Load()))
+	defer cancel()
+
+	if err := Flush(ctx); err != nil {
+		fmt.Printf("Unable to flush records after panic: %s\n", err.Error())
+	}
+}
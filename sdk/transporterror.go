@@ -0,0 +1,111 @@
+package sdk
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// TransportErrorHeader is set by the runtime when the recorded call failed instead of getting a response
+const TransportErrorHeader = "X-Replay-Transport-Error"
+
+// Kinds of transport errors, recorded in front of the error message
+const (
+	TimeoutError  = "timeout"
+	RefusedError  = "refused"
+	ResetError    = "reset"
+	DNSError      = "dns"
+	TLSError      = "tls"
+	CanceledError = "canceled"
+	OtherError    = "other"
+)
+
+// TransportError is returned by the transport during replay in place of the error the call failed with
+// when it was recorded. It unwraps to the usual error of its kind, so errors.Is and net.Error checks
+// made by the service behave as they did.
+type TransportError struct {
+	Kind    string
+	Message string
+}
+
+func (e *TransportError) Error() string {
+	return e.Message
+}
+
+func (e *TransportError) Timeout() bool {
+	return e.Kind == TimeoutError
+}
+
+func (e *TransportError) Temporary() bool {
+	return e.Kind == TimeoutError
+}
+
+func (e *TransportError) Unwrap() error {
+	switch e.Kind {
+	case TimeoutError:
+		return os.ErrDeadlineExceeded
+	case RefusedError:
+		return syscall.ECONNREFUSED
+	case ResetError:
+		return syscall.ECONNRESET
+	case CanceledError:
+		return context.Canceled
+	case DNSError:
+		return &net.DNSError{Err: e.Message, IsNotFound: true}
+	}
+	return nil
+}
+
+// transportErrorKind classifies the error a dependency call failed with
+func transportErrorKind(err error) string {
+	var (
+		netErr       net.Error
+		dnsErr       *net.DNSError
+		verifyErr    *tls.CertificateVerificationError
+		recordErr    tls.RecordHeaderError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	// A replayed error keeps the kind it was recorded with
+	var replayed *TransportError
+	if errors.As(err, &replayed) {
+		return replayed.Kind
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return CanceledError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return TimeoutError
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return RefusedError
+	case errors.Is(err, syscall.ECONNRESET):
+		return ResetError
+	case errors.As(err, &dnsErr):
+		return DNSError
+	case errors.As(err, &verifyErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return TLSError
+	}
+	return OtherError
+}
+
+// encodeTransportError is what a failed call records as its error
+func encodeTransportError(err error) string {
+	return transportErrorKind(err) + ": " + err.Error()
+}
+
+func decodeTransportError(value string) *TransportError {
+	kind, message, ok := strings.Cut(value, ": ")
+	if !ok {
+		return &TransportError{Kind: OtherError, Message: value}
+	}
+	return &TransportError{Kind: kind, Message: message}
+}
@@ -0,0 +1,84 @@
+package sdk
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func closedAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return addr
+}
+
+func TestTransportErrorKind(t *testing.T) {
+	_, err := http.DefaultTransport.RoundTrip(httptest.NewRequest("GET", "http://"+closedAddress(t), nil))
+	if act := transportErrorKind(err); act != RefusedError {
+		t.Errorf("Want %v Actual %v %v\n", RefusedError, act, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	r, _ := http.NewRequestWithContext(ctx, "GET", "http://"+closedAddress(t), nil)
+	_, err = http.DefaultTransport.RoundTrip(r)
+	if act := transportErrorKind(err); act != TimeoutError {
+		t.Errorf("Want %v Actual %v %v\n", TimeoutError, act, err)
+	}
+
+	replayed := decodeTransportError(encodeTransportError(&TransportError{Kind: TLSError, Message: "bad certificate"}))
+	if replayed.Kind != TLSError || replayed.Message != "bad certificate" {
+		t.Errorf("Want %v Actual %v\n", TLSError, replayed)
+	}
+}
+
+func TestReplayTransportError(t *testing.T) {
+	runtime := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(TransportErrorHeader, "refused: dial tcp 10.0.0.1:80: connect: connection refused")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer runtime.Close()
+
+	sc := &ServiceContext{RequestContext: "rc", ExecutionContext: "ec", Debug: true, DebugHost: runtime.URL, scopedSequenc: map[string]int{}}
+	ctx := context.WithValue(context.Background(), serviceContextKey, sc)
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport}}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://b/count", nil)
+	_, err := client.Do(req)
+
+	var netErr net.Error
+	if !errors.Is(err, syscall.ECONNREFUSED) || !errors.As(err, &netErr) || netErr.Timeout() {
+		t.Errorf("Want %v Actual %v\n", syscall.ECONNREFUSED, err)
+	}
+	if want := `Get "http://b/count": dial tcp 10.0.0.1:80: connect: connection refused`; err == nil || err.Error() != want {
+		t.Errorf("Want %v Actual %v\n", want, err)
+	}
+}
+
+func TestRecordTransportError(t *testing.T) {
+	logEnabled = true
+	defer func() { logEnabled = false }()
+
+	sc := &ServiceContext{RequestContext: "rc", ExecutionContext: "ec", Capture: true, scopedSequenc: map[string]int{}}
+	ctx := context.WithValue(context.Background(), serviceContextKey, sc)
+	client := &http.Client{Transport: &Transport{Base: http.DefaultTransport}}
+
+	// The failed call is logged without a response
+	req, _ := http.NewRequestWithContext(ctx, "GET", "http://"+closedAddress(t), nil)
+	if _, err := client.Do(req); err == nil {
+		t.Errorf("Want %v Actual %v\n", "error", err)
+	}
+	if err := waitForLogs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}